@@ -22,6 +22,7 @@ import (
 	"os"
 
 	"github.com/kubernetes-csi/drivers/pkg/cinder"
+	"github.com/kubernetes-csi/drivers/pkg/csi-common"
 	"github.com/spf13/cobra"
 )
 
@@ -33,6 +34,7 @@ var (
 
 func init() {
 	flag.Set("logtostderr", "true")
+	csicommon.AddServerFlags(flag.CommandLine)
 }
 
 func main() {
@@ -43,7 +45,10 @@ func main() {
 		Use:   "Cinder",
 		Short: "CSI based Cinder driver",
 		Run: func(cmd *cobra.Command, args []string) {
-			handle()
+			if err := handle(); err != nil {
+				fmt.Fprintf(os.Stderr, "%s", err.Error())
+				os.Exit(1)
+			}
 		},
 	}
 
@@ -66,7 +71,7 @@ func main() {
 	os.Exit(0)
 }
 
-func handle() {
+func handle() error {
 	d := cinder.NewDriver(nodeID, endpoint, cloudconfig)
-	d.Run()
+	return d.Run()
 }
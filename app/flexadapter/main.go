@@ -23,6 +23,7 @@ import (
 
 	"github.com/spf13/cobra"
 
+	"github.com/kubernetes-csi/drivers/pkg/csi-common"
 	"github.com/kubernetes-csi/drivers/pkg/flexadapter"
 )
 
@@ -35,6 +36,7 @@ var (
 
 func init() {
 	flag.Set("logtostderr", "true")
+	csicommon.AddServerFlags(flag.CommandLine)
 }
 
 func main() {
@@ -45,7 +47,10 @@ func main() {
 		Use:   "flexadapter",
 		Short: "Flex volume adapter for CSI",
 		Run: func(cmd *cobra.Command, args []string) {
-			handle()
+			if err := handle(); err != nil {
+				fmt.Fprintf(os.Stderr, "%s", err.Error())
+				os.Exit(1)
+			}
 		},
 	}
 
@@ -71,7 +76,7 @@ func main() {
 	os.Exit(0)
 }
 
-func handle() {
+func handle() error {
 	adapter := flexadapter.New()
-	adapter.Run(driverName, driverPath, nodeID, endpoint)
+	return adapter.Run(driverName, driverPath, nodeID, endpoint)
 }
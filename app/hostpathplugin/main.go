@@ -18,29 +18,36 @@ package main
 
 import (
 	"flag"
+	"fmt"
 	"os"
 
+	"github.com/kubernetes-csi/drivers/pkg/csi-common"
 	"github.com/kubernetes-csi/drivers/pkg/hostpath"
 )
 
 func init() {
 	flag.Set("logtostderr", "true")
+	csicommon.AddServerFlags(flag.CommandLine)
 }
 
 var (
 	endpoint   = flag.String("endpoint", "unix://tmp/csi.sock", "CSI endpoint")
 	driverName = flag.String("drivername", "csi-hostpath", "name of the driver")
 	nodeID     = flag.String("nodeid", "", "node id")
+	stateDir   = flag.String("statedir", "/var/lib/csi-hostpath", "directory where volume metadata is persisted")
 )
 
 func main() {
 	flag.Parse()
 
-	handle()
+	if err := handle(); err != nil {
+		fmt.Fprintf(os.Stderr, "%s", err.Error())
+		os.Exit(1)
+	}
 	os.Exit(0)
 }
 
-func handle() {
+func handle() error {
 	driver := hostpath.GetHostPathDriver()
-	driver.Run(*driverName, *nodeID, *endpoint)
+	return driver.Run(*driverName, *nodeID, *endpoint, *stateDir)
 }
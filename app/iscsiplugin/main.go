@@ -20,19 +20,30 @@ import (
 	"flag"
 	"fmt"
 	"os"
+	"time"
 
 	"github.com/spf13/cobra"
 
+	"github.com/kubernetes-csi/drivers/pkg/csi-common"
 	"github.com/kubernetes-csi/drivers/pkg/iscsi"
 )
 
 var (
 	endpoint string
 	nodeID   string
+	stateDir string
+
+	targetIQN    string
+	targetPortal string
+	backstoreDir string
+
+	reconcilePeriod time.Duration
+	reconcileDryRun bool
 )
 
 func init() {
 	flag.Set("logtostderr", "true")
+	csicommon.AddServerFlags(flag.CommandLine)
 }
 
 func main() {
@@ -43,7 +54,10 @@ func main() {
 		Use:   "ISCSI",
 		Short: "CSI based ISCSI driver",
 		Run: func(cmd *cobra.Command, args []string) {
-			handle()
+			if err := handle(); err != nil {
+				fmt.Fprintf(os.Stderr, "%s", err.Error())
+				os.Exit(1)
+			}
 		},
 	}
 
@@ -55,6 +69,15 @@ func main() {
 	cmd.PersistentFlags().StringVar(&endpoint, "endpoint", "", "CSI endpoint")
 	cmd.MarkPersistentFlagRequired("endpoint")
 
+	cmd.PersistentFlags().StringVar(&stateDir, "state-dir", iscsi.DefaultStateDir, "directory holding the connection records of the staged volumes")
+
+	cmd.PersistentFlags().DurationVar(&reconcilePeriod, "reconcile-period", 5*time.Minute, "how often to clean up the leaked iSCSI sessions and ifaces, 0 to only clean them up on startup")
+	cmd.PersistentFlags().BoolVar(&reconcileDryRun, "reconcile-dry-run", false, "only report the leaked iSCSI sessions and ifaces")
+
+	cmd.PersistentFlags().StringVar(&targetIQN, "target-iqn", "", "name of the LIO target to provision volumes in, enables the controller server")
+	cmd.PersistentFlags().StringVar(&targetPortal, "target-portal", "", "portal the nodes log in to the target through")
+	cmd.PersistentFlags().StringVar(&backstoreDir, "backstore-dir", "/var/lib/csi-iscsi/backstores", "directory holding the backing files of the provisioned volumes")
+
 	if err := cmd.Execute(); err != nil {
 		fmt.Fprintf(os.Stderr, "%s", err.Error())
 		os.Exit(1)
@@ -63,7 +86,19 @@ func main() {
 	os.Exit(0)
 }
 
-func handle() {
-	d := iscsi.NewDriver(nodeID, endpoint)
-	d.Run()
+func handle() error {
+	var controller *iscsi.ControllerConfig
+	if targetIQN != "" {
+		if targetPortal == "" {
+			return fmt.Errorf("--target-portal is required with --target-iqn")
+		}
+		controller = &iscsi.ControllerConfig{
+			TargetIQN:    targetIQN,
+			TargetPortal: targetPortal,
+			BackstoreDir: backstoreDir,
+		}
+	}
+	d := iscsi.NewDriver(nodeID, endpoint, stateDir, controller)
+	d.ConfigureReconciler(reconcilePeriod, reconcileDryRun)
+	return d.Run()
 }
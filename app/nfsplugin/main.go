@@ -23,6 +23,7 @@ import (
 
 	"github.com/spf13/cobra"
 
+	"github.com/kubernetes-csi/drivers/pkg/csi-common"
 	"github.com/kubernetes-csi/drivers/pkg/nfs"
 )
 
@@ -33,6 +34,7 @@ var (
 
 func init() {
 	flag.Set("logtostderr", "true")
+	csicommon.AddServerFlags(flag.CommandLine)
 }
 
 func main() {
@@ -43,7 +45,10 @@ func main() {
 		Use:   "NFS",
 		Short: "CSI based NFS driver",
 		Run: func(cmd *cobra.Command, args []string) {
-			handle()
+			if err := handle(); err != nil {
+				fmt.Fprintf(os.Stderr, "%s", err.Error())
+				os.Exit(1)
+			}
 		},
 	}
 
@@ -64,7 +69,7 @@ func main() {
 	os.Exit(0)
 }
 
-func handle() {
+func handle() error {
 	d := nfs.NewDriver(nodeID, endpoint)
-	d.Run()
+	return d.Run()
 }
@@ -23,11 +23,14 @@ import (
 	csicommon "github.com/kubernetes-csi/drivers/pkg/csi-common"
 	"github.com/pborman/uuid"
 	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 	"k8s.io/kubernetes/pkg/volume/util"
 )
 
 type controllerServer struct {
 	*csicommon.DefaultControllerServer
+	volumeLocks *csicommon.VolumeLocks
 }
 
 func (cs *controllerServer) CreateVolume(ctx context.Context, req *csi.CreateVolumeRequest) (*csi.CreateVolumeResponse, error) {
@@ -37,6 +40,10 @@ func (cs *controllerServer) CreateVolume(ctx context.Context, req *csi.CreateVol
 	if len(volName) == 0 {
 		volName = uuid.NewUUID().String()
 	}
+	if err := cs.volumeLocks.Acquire(volName); err != nil {
+		return nil, err
+	}
+	defer cs.volumeLocks.Release(volName)
 
 	// Volume Size - Default is 1 GiB
 	volSizeBytes := int64(1 * 1024 * 1024 * 1024)
@@ -51,6 +58,9 @@ func (cs *controllerServer) CreateVolume(ctx context.Context, req *csi.CreateVol
 	// Volume Availability - Default is nova
 	volAvailability := req.GetParameters()["availability"]
 
+	// Source Snapshot - Default is an empty volume
+	snapshotID := req.GetParameters()["snapshotID"]
+
 	// Get OpenStack Provider
 	cloud, err := openstack.GetOpenStackProvider()
 	if err != nil {
@@ -58,27 +68,99 @@ func (cs *controllerServer) CreateVolume(ctx context.Context, req *csi.CreateVol
 		return nil, err
 	}
 
-	// Volume Create
-	resID, resAvailability, err := cloud.CreateVolume(volName, volSizeGB, volType, volAvailability, nil)
+	// Volume Lookup - a retried request returns the volume created first,
+	// even if its source snapshot was deleted since
+	vols, err := cloud.GetVolumesByName(volName)
 	if err != nil {
-		glog.V(3).Infof("Failed to CreateVolume: %v", err)
+		glog.V(3).Infof("Failed to GetVolumesByName: %v", err)
 		return nil, err
 	}
+	if len(vols) > 1 {
+		return nil, status.Errorf(codes.Internal, "Multiple volumes named %s found", volName)
+	}
+	var resID, resAvailability string
+	if len(vols) == 1 {
+		vol := vols[0]
+		// A volume restored from a snapshot may have been grown to its size
+		sizeMatches := vol.Size == volSizeGB
+		if snapshotID != "" {
+			sizeMatches = vol.SnapshotID == snapshotID && vol.Size >= volSizeGB
+		}
+		if !sizeMatches || (volType != "" && vol.VolumeType != volType) {
+			return nil, status.Errorf(codes.AlreadyExists, "Volume %s already exists as %s with %d GB of type %q", volName, vol.ID, vol.Size, vol.VolumeType)
+		}
+		glog.V(4).Infof("Volume %s already exists as %s", volName, vol.ID)
+		resID, resAvailability, volSizeGB = vol.ID, vol.AvailabilityZone, vol.Size
+		// A volume which was already used does not need waiting for
+		if vol.Status == openstack.VolumeAvailableStatus || vol.Status == openstack.VolumeInUseStatus {
+			return createVolumeResponse(resID, resAvailability, volSizeGB), nil
+		}
+	} else {
+		if snapshotID != "" {
+			snap, err := cloud.GetSnapshotByID(snapshotID)
+			if err != nil {
+				glog.V(3).Infof("Failed to GetSnapshotByID: %v", err)
+				if openstack.IsNotFound(err) {
+					return nil, status.Errorf(codes.NotFound, "Source snapshot %s not found: %v", snapshotID, err)
+				}
+				return nil, status.Errorf(codes.Internal, "Failed to get source snapshot %s: %v", snapshotID, err)
+			}
+			if snap.Status != openstack.SnapshotReadyStatus {
+				return nil, status.Errorf(codes.FailedPrecondition, "Source snapshot %s is %s, not %s", snapshotID, snap.Status, openstack.SnapshotReadyStatus)
+			}
+			// The volume cannot be smaller than the snapshot
+			if volSizeGB < snap.Size {
+				glog.V(4).Infof("Growing volume %s to the %d GB of snapshot %s", volName, snap.Size, snapshotID)
+				volSizeGB = snap.Size
+			}
+		}
+
+		// Volume Create
+		resID, resAvailability, err = cloud.CreateVolume(volName, volSizeGB, volType, volAvailability, snapshotID, nil)
+		if err != nil {
+			glog.V(3).Infof("Failed to CreateVolume: %v", err)
+			return nil, err
+		}
+	}
+
+	// Volume Wait - a volume which failed is deleted so that a retry starts
+	// over, one which is still creating is waited for again by the retry
+	if err := cloud.WaitVolumeReady(resID); err != nil {
+		glog.V(3).Infof("Failed to WaitVolumeReady: %v", err)
+		if !openstack.IsVolumeFailed(err) {
+			return nil, status.Errorf(codes.DeadlineExceeded, "Volume %s is not available yet: %v", resID, err)
+		}
+		if derr := cloud.DeleteVolume(resID); derr != nil {
+			glog.Warningf("Failed to delete volume %s: %v", resID, derr)
+		}
+		return nil, status.Errorf(codes.Internal, "Volume %s failed to be created: %v", resID, err)
+	}
 
 	glog.V(4).Infof("Create volume %s in Availability Zone: %s", resID, resAvailability)
 
+	return createVolumeResponse(resID, resAvailability, volSizeGB), nil
+}
+
+func createVolumeResponse(volID, availability string, sizeGB int) *csi.CreateVolumeResponse {
 	return &csi.CreateVolumeResponse{
 		Volume: &csi.Volume{
-			Id: resID,
+			Id:            volID,
+			CapacityBytes: int64(sizeGB) * 1024 * 1024 * 1024,
 			Attributes: map[string]string{
-				"availability": resAvailability,
+				"availability": availability,
 			},
 		},
-	}, nil
+	}
 }
 
 func (cs *controllerServer) DeleteVolume(ctx context.Context, req *csi.DeleteVolumeRequest) (*csi.DeleteVolumeResponse, error) {
 
+	volID := req.GetVolumeId()
+	if err := cs.volumeLocks.Acquire(volID); err != nil {
+		return nil, err
+	}
+	defer cs.volumeLocks.Release(volID)
+
 	// Get OpenStack Provider
 	cloud, err := openstack.GetOpenStackProvider()
 	if err != nil {
@@ -87,8 +169,11 @@ func (cs *controllerServer) DeleteVolume(ctx context.Context, req *csi.DeleteVol
 	}
 
 	// Volume Delete
-	volID := req.GetVolumeId()
 	err = cloud.DeleteVolume(volID)
+	if openstack.IsNotFound(err) {
+		glog.V(4).Infof("Volume %s is already deleted", volID)
+		return &csi.DeleteVolumeResponse{}, nil
+	}
 	if err != nil {
 		glog.V(3).Infof("Failed to DeleteVolume: %v", err)
 		return nil, err
@@ -101,6 +186,13 @@ func (cs *controllerServer) DeleteVolume(ctx context.Context, req *csi.DeleteVol
 
 func (cs *controllerServer) ControllerPublishVolume(ctx context.Context, req *csi.ControllerPublishVolumeRequest) (*csi.ControllerPublishVolumeResponse, error) {
 
+	instanceID := req.GetNodeId()
+	volumeID := req.GetVolumeId()
+	if err := cs.volumeLocks.Acquire(volumeID); err != nil {
+		return nil, err
+	}
+	defer cs.volumeLocks.Release(volumeID)
+
 	// Get OpenStack Provider
 	cloud, err := openstack.GetOpenStackProvider()
 	if err != nil {
@@ -109,9 +201,6 @@ func (cs *controllerServer) ControllerPublishVolume(ctx context.Context, req *cs
 	}
 
 	// Volume Attach
-	instanceID := req.GetNodeId()
-	volumeID := req.GetVolumeId()
-
 	_, err = cloud.AttachVolume(instanceID, volumeID)
 	if err != nil {
 		glog.V(3).Infof("Failed to AttachVolume: %v", err)
@@ -143,6 +232,13 @@ func (cs *controllerServer) ControllerPublishVolume(ctx context.Context, req *cs
 
 func (cs *controllerServer) ControllerUnpublishVolume(ctx context.Context, req *csi.ControllerUnpublishVolumeRequest) (*csi.ControllerUnpublishVolumeResponse, error) {
 
+	instanceID := req.GetNodeId()
+	volumeID := req.GetVolumeId()
+	if err := cs.volumeLocks.Acquire(volumeID); err != nil {
+		return nil, err
+	}
+	defer cs.volumeLocks.Release(volumeID)
+
 	// Get OpenStack Provider
 	cloud, err := openstack.GetOpenStackProvider()
 	if err != nil {
@@ -151,9 +247,6 @@ func (cs *controllerServer) ControllerUnpublishVolume(ctx context.Context, req *
 	}
 
 	// Volume Detach
-	instanceID := req.GetNodeId()
-	volumeID := req.GetVolumeId()
-
 	err = cloud.DetachVolume(instanceID, volumeID)
 	if err != nil {
 		glog.V(3).Infof("Failed to DetachVolume: %v", err)
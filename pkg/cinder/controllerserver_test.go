@@ -17,12 +17,16 @@ limitations under the License.
 package cinder
 
 import (
+	"errors"
 	"testing"
 
 	"github.com/container-storage-interface/spec/lib/go/csi/v0"
+	"github.com/gophercloud/gophercloud"
 	"github.com/kubernetes-csi/drivers/pkg/cinder/openstack"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 var fakeCs *controllerServer
@@ -40,8 +44,12 @@ func TestCreateVolume(t *testing.T) {
 
 	// mock OpenStack
 	osmock := new(openstack.OpenStackMock)
-	// CreateVolume(name string, size int, vtype, availability string, tags *map[string]string) (string, string, error)
-	osmock.On("CreateVolume", fakeVolName, mock.AnythingOfType("int"), fakeVolType, fakeAvailability, (*map[string]string)(nil)).Return(fakeVolID, fakeAvailability, nil)
+	// GetVolumesByName(name string) ([]Volume, error)
+	osmock.On("GetVolumesByName", fakeVolName).Return(nil, nil)
+	// CreateVolume(name string, size int, vtype, availability string, snapshotID string, tags *map[string]string) (string, string, error)
+	osmock.On("CreateVolume", fakeVolName, mock.AnythingOfType("int"), fakeVolType, fakeAvailability, "", (*map[string]string)(nil)).Return(fakeVolID, fakeAvailability, nil)
+	// WaitVolumeReady(volumeID string) error
+	osmock.On("WaitVolumeReady", fakeVolID).Return(nil)
 	openstack.OsInstance = osmock
 
 	// Init assert
@@ -64,9 +72,254 @@ func TestCreateVolume(t *testing.T) {
 
 	assert.NotEqual(0, len(actualRes.Volume.Id), "Volume Id is nil")
 
+	assert.Equal(int64(1024*1024*1024), actualRes.Volume.CapacityBytes)
+
 	assert.Equal(fakeAvailability, actualRes.Volume.Attributes["availability"])
 }
 
+// Test CreateVolume of a volume which lands in error state
+func TestCreateVolumeFailed(t *testing.T) {
+
+	// mock OpenStack
+	osmock := new(openstack.OpenStackMock)
+	osmock.On("GetVolumesByName", fakeVolName).Return(nil, nil)
+	osmock.On("CreateVolume", fakeVolName, mock.AnythingOfType("int"), fakeVolType, fakeAvailability, "", (*map[string]string)(nil)).Return(fakeVolID, fakeAvailability, nil)
+	osmock.On("WaitVolumeReady", fakeVolID).Return(openstack.VolumeFailedError{VolumeID: fakeVolID})
+	// The failed volume is deleted
+	osmock.On("DeleteVolume", fakeVolID).Return(nil)
+	openstack.OsInstance = osmock
+
+	// Fake request
+	fakeReq := &csi.CreateVolumeRequest{
+		Name: fakeVolName,
+	}
+
+	// Invoke CreateVolume
+	_, err := fakeCs.CreateVolume(fakeCtx, fakeReq)
+
+	// Assert
+	assert.Equal(t, codes.Internal, status.Code(err))
+	osmock.AssertExpectations(t)
+}
+
+// Test CreateVolume of a volume which is still creating
+func TestCreateVolumeTimeout(t *testing.T) {
+
+	// mock OpenStack
+	osmock := new(openstack.OpenStackMock)
+	osmock.On("GetVolumesByName", fakeVolName).Return([]openstack.Volume{{ID: fakeVolID, Status: "creating", Size: 1}}, nil)
+	osmock.On("WaitVolumeReady", fakeVolID).Return(errors.New("timeout"))
+	openstack.OsInstance = osmock
+
+	// Fake request
+	fakeReq := &csi.CreateVolumeRequest{
+		Name: fakeVolName,
+	}
+
+	// Invoke CreateVolume
+	_, err := fakeCs.CreateVolume(fakeCtx, fakeReq)
+
+	// Assert
+	assert.Equal(t, codes.DeadlineExceeded, status.Code(err))
+	osmock.AssertNotCalled(t, "DeleteVolume", fakeVolID)
+}
+
+// Test CreateVolume of a volume which already exists
+func TestCreateVolumeExisting(t *testing.T) {
+
+	// mock OpenStack
+	osmock := new(openstack.OpenStackMock)
+	osmock.On("GetVolumesByName", fakeVolName).Return([]openstack.Volume{{ID: fakeVolID, Status: openstack.VolumeAvailableStatus, Size: 1, AvailabilityZone: "nova"}}, nil)
+	openstack.OsInstance = osmock
+
+	// Init assert
+	assert := assert.New(t)
+
+	// Fake request
+	fakeReq := &csi.CreateVolumeRequest{
+		Name: fakeVolName,
+	}
+
+	// Invoke CreateVolume
+	actualRes, err := fakeCs.CreateVolume(fakeCtx, fakeReq)
+	if err != nil {
+		t.Errorf("failed to CreateVolume: %v", err)
+	}
+
+	// Assert
+	assert.Equal(fakeVolID, actualRes.Volume.Id)
+	assert.Equal("nova", actualRes.Volume.Attributes["availability"])
+	osmock.AssertNotCalled(t, "CreateVolume", fakeVolName, mock.AnythingOfType("int"), fakeVolType, fakeAvailability, "", (*map[string]string)(nil))
+}
+
+// Test CreateVolume of a volume which already exists with another size
+func TestCreateVolumeExistingMismatch(t *testing.T) {
+
+	// mock OpenStack
+	osmock := new(openstack.OpenStackMock)
+	osmock.On("GetVolumesByName", fakeVolName).Return([]openstack.Volume{{ID: fakeVolID, Size: 1}}, nil)
+	openstack.OsInstance = osmock
+
+	// Fake request
+	fakeReq := &csi.CreateVolumeRequest{
+		Name:          fakeVolName,
+		CapacityRange: &csi.CapacityRange{RequiredBytes: 2 * 1024 * 1024 * 1024},
+	}
+
+	// Invoke CreateVolume
+	_, err := fakeCs.CreateVolume(fakeCtx, fakeReq)
+
+	// Assert
+	assert.Equal(t, codes.AlreadyExists, status.Code(err))
+	osmock.AssertNotCalled(t, "CreateVolume", fakeVolName, 2, fakeVolType, fakeAvailability, "", (*map[string]string)(nil))
+}
+
+// Test CreateVolume from a snapshot
+func TestCreateVolumeFromSnapshot(t *testing.T) {
+
+	// mock OpenStack
+	osmock := new(openstack.OpenStackMock)
+	// GetSnapshotByID(snapshotID string) (Snapshot, error)
+	osmock.On("GetSnapshotByID", fakeSnapshotID).Return(openstack.Snapshot{ID: fakeSnapshotID, Status: openstack.SnapshotReadyStatus, Size: 2}, nil)
+	osmock.On("GetVolumesByName", fakeVolName).Return(nil, nil)
+	// The volume is as large as the snapshot
+	osmock.On("CreateVolume", fakeVolName, 2, fakeVolType, fakeAvailability, fakeSnapshotID, (*map[string]string)(nil)).Return(fakeVolID, fakeAvailability, nil)
+	// WaitVolumeReady(volumeID string) error
+	osmock.On("WaitVolumeReady", fakeVolID).Return(nil)
+	openstack.OsInstance = osmock
+
+	// Init assert
+	assert := assert.New(t)
+
+	// Fake request
+	fakeReq := &csi.CreateVolumeRequest{
+		Name:       fakeVolName,
+		Parameters: map[string]string{"snapshotID": fakeSnapshotID},
+	}
+
+	// Invoke CreateVolume
+	actualRes, err := fakeCs.CreateVolume(fakeCtx, fakeReq)
+	if err != nil {
+		t.Errorf("failed to CreateVolume: %v", err)
+	}
+
+	// Assert
+	assert.Equal(fakeVolID, actualRes.Volume.Id)
+	assert.Equal(int64(2*1024*1024*1024), actualRes.Volume.CapacityBytes)
+	osmock.AssertExpectations(t)
+}
+
+// Test CreateVolume from a snapshot which is not ready
+func TestCreateVolumeFromSnapshotNotReady(t *testing.T) {
+
+	// mock OpenStack
+	osmock := new(openstack.OpenStackMock)
+	osmock.On("GetSnapshotByID", fakeSnapshotID).Return(openstack.Snapshot{ID: fakeSnapshotID, Status: "creating", Size: 1}, nil)
+	osmock.On("GetVolumesByName", fakeVolName).Return(nil, nil)
+	openstack.OsInstance = osmock
+
+	// Fake request
+	fakeReq := &csi.CreateVolumeRequest{
+		Name:       fakeVolName,
+		Parameters: map[string]string{"snapshotID": fakeSnapshotID},
+	}
+
+	// Invoke CreateVolume
+	_, err := fakeCs.CreateVolume(fakeCtx, fakeReq)
+
+	// Assert
+	assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+	osmock.AssertNotCalled(t, "CreateVolume", fakeVolName, mock.AnythingOfType("int"), fakeVolType, fakeAvailability, fakeSnapshotID, (*map[string]string)(nil))
+}
+
+// Test CreateVolume from a snapshot which cannot be retrieved
+func TestCreateVolumeFromSnapshotLookupError(t *testing.T) {
+	tests := []struct {
+		err  error
+		code codes.Code
+	}{
+		{gophercloud.ErrDefault404{}, codes.NotFound},
+		{gophercloud.ErrDefault500{}, codes.Internal},
+	}
+	for _, test := range tests {
+		// mock OpenStack
+		osmock := new(openstack.OpenStackMock)
+		osmock.On("GetVolumesByName", fakeVolName).Return(nil, nil)
+		osmock.On("GetSnapshotByID", fakeSnapshotID).Return(openstack.Snapshot{}, test.err)
+		openstack.OsInstance = osmock
+
+		// Fake request
+		fakeReq := &csi.CreateVolumeRequest{
+			Name:       fakeVolName,
+			Parameters: map[string]string{"snapshotID": fakeSnapshotID},
+		}
+
+		// Invoke CreateVolume
+		_, err := fakeCs.CreateVolume(fakeCtx, fakeReq)
+
+		// Assert
+		assert.Equal(t, test.code, status.Code(err))
+	}
+}
+
+// Test CreateVolume retried after its source snapshot was deleted
+func TestCreateVolumeFromDeletedSnapshotExisting(t *testing.T) {
+
+	// mock OpenStack
+	osmock := new(openstack.OpenStackMock)
+	// The volume was grown to the size of the snapshot
+	osmock.On("GetVolumesByName", fakeVolName).Return([]openstack.Volume{{ID: fakeVolID, Name: fakeVolName, Status: openstack.VolumeAvailableStatus, Size: 2, AvailabilityZone: fakeAvailability, SnapshotID: fakeSnapshotID}}, nil)
+	openstack.OsInstance = osmock
+
+	// Init assert
+	assert := assert.New(t)
+
+	// Fake request
+	fakeReq := &csi.CreateVolumeRequest{
+		Name:       fakeVolName,
+		Parameters: map[string]string{"snapshotID": fakeSnapshotID},
+	}
+
+	// Invoke CreateVolume
+	actualRes, err := fakeCs.CreateVolume(fakeCtx, fakeReq)
+	if err != nil {
+		t.Errorf("failed to CreateVolume: %v", err)
+	}
+
+	// Assert
+	assert.Equal(fakeVolID, actualRes.Volume.Id)
+	assert.Equal(int64(2*1024*1024*1024), actualRes.Volume.CapacityBytes)
+	osmock.AssertNotCalled(t, "GetSnapshotByID", fakeSnapshotID)
+}
+
+// Test CreateVolume while another operation on the same name is in flight
+func TestCreateVolumeInProgress(t *testing.T) {
+
+	// mock OpenStack
+	osmock := new(openstack.OpenStackMock)
+	openstack.OsInstance = osmock
+
+	// Init assert
+	assert := assert.New(t)
+
+	// Fake request
+	fakeReq := &csi.CreateVolumeRequest{
+		Name: fakeVolName,
+	}
+
+	fakeCs.volumeLocks.TryAcquire(fakeVolName)
+	defer fakeCs.volumeLocks.Release(fakeVolName)
+
+	// Invoke CreateVolume
+	_, err := fakeCs.CreateVolume(fakeCtx, fakeReq)
+
+	// Assert
+	s, ok := status.FromError(err)
+	assert.True(ok)
+	assert.Equal(codes.Aborted, s.Code())
+	osmock.AssertNotCalled(t, "CreateVolume", fakeVolName, mock.AnythingOfType("int"), fakeVolType, fakeAvailability, "", (*map[string]string)(nil))
+}
+
 // Test DeleteVolume
 func TestDeleteVolume(t *testing.T) {
 
@@ -97,6 +350,27 @@ func TestDeleteVolume(t *testing.T) {
 	assert.Equal(expectedRes, actualRes)
 }
 
+// Test DeleteVolume of a volume which is already gone
+func TestDeleteVolumeNotFound(t *testing.T) {
+
+	// mock OpenStack
+	osmock := new(openstack.OpenStackMock)
+	osmock.On("DeleteVolume", fakeVolID).Return(gophercloud.ErrDefault404{})
+	openstack.OsInstance = osmock
+
+	// Fake request
+	fakeReq := &csi.DeleteVolumeRequest{
+		VolumeId: fakeVolID,
+	}
+
+	// Invoke DeleteVolume
+	actualRes, err := fakeCs.DeleteVolume(fakeCtx, fakeReq)
+
+	// Assert
+	assert.NoError(t, err)
+	assert.Equal(t, &csi.DeleteVolumeResponse{}, actualRes)
+}
+
 // Test ControllerPublishVolume
 func TestControllerPublishVolume(t *testing.T) {
 
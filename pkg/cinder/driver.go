@@ -17,6 +17,8 @@ limitations under the License.
 package cinder
 
 import (
+	"fmt"
+
 	"github.com/container-storage-interface/spec/lib/go/csi/v0"
 	"github.com/golang/glog"
 
@@ -60,6 +62,8 @@ func NewDriver(nodeID, endpoint string, cloudconfig string) *driver {
 			csi.ControllerServiceCapability_RPC_PUBLISH_UNPUBLISH_VOLUME,
 		})
 	csiDriver.AddVolumeCapabilityAccessModes([]csi.VolumeCapability_AccessMode_Mode{csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER})
+	csiDriver.EnableBlockVolumes()
+	csiDriver.AddHealthCheck("openstack", checkOpenStack)
 
 	d.csiDriver = csiDriver
 
@@ -69,16 +73,27 @@ func NewDriver(nodeID, endpoint string, cloudconfig string) *driver {
 func NewControllerServer(d *driver) *controllerServer {
 	return &controllerServer{
 		DefaultControllerServer: csicommon.NewDefaultControllerServer(d.csiDriver),
+		volumeLocks:             csicommon.NewVolumeLocks(),
 	}
 }
 
 func NewNodeServer(d *driver) *nodeServer {
 	return &nodeServer{
 		DefaultNodeServer: csicommon.NewDefaultNodeServer(d.csiDriver),
+		volumeLocks:       csicommon.NewVolumeLocks(),
 	}
 }
 
-func (d *driver) Run() {
+func (d *driver) Run() error {
 	openstack.InitOpenStackProvider(d.cloudconfig)
-	csicommon.RunControllerandNodePublishServer(d.endpoint, d.csiDriver, NewControllerServer(d), NewNodeServer(d))
+	return csicommon.RunControllerandNodePublishServer(d.endpoint, d.csiDriver, NewControllerServer(d), NewNodeServer(d))
+}
+
+// checkOpenStack verifies that the driver can authenticate to OpenStack.
+func checkOpenStack() error {
+	cloud, err := openstack.GetOpenStackProvider()
+	if err != nil {
+		return fmt.Errorf("failed to get OpenStack provider: %v", err)
+	}
+	return cloud.CheckToken()
 }
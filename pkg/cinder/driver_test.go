@@ -0,0 +1,50 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cinder
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi/v0"
+	"github.com/kubernetes-csi/drivers/pkg/cinder/openstack"
+	"github.com/kubernetes-csi/drivers/pkg/csi-common"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Test Probe
+func TestProbe(t *testing.T) {
+	d := NewDriver(fakeNodeID, fakeEndpoint, fakeConfig)
+	ids := csicommon.NewDefaultIdentityServer(d.csiDriver)
+
+	// mock OpenStack
+	osmock := new(openstack.OpenStackMock)
+	osmock.On("CheckToken").Return(nil).Once()
+	osmock.On("CheckToken").Return(errors.New("token expired")).Once()
+	openstack.OsInstance = osmock
+
+	_, err := ids.Probe(fakeCtx, &csi.ProbeRequest{})
+	assert.NoError(t, err)
+
+	_, err = ids.Probe(fakeCtx, &csi.ProbeRequest{})
+	assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+	assert.Contains(t, err.Error(), "token expired")
+
+	osmock.AssertExpectations(t)
+}
@@ -26,6 +26,7 @@ var fakeConfig = "/etc/cloud.conf"
 var fakeCtx = context.Background()
 var fakeVolName = "CSIVolumeName"
 var fakeVolID = "CSIVolumeID"
+var fakeSnapshotID = "CSISnapshotID"
 var fakeVolType = ""
 var fakeAvailability = ""
 var fakeDevicePath = "/dev/xxx"
@@ -28,6 +28,8 @@ import (
 	utilexec "k8s.io/utils/exec"
 
 	"github.com/golang/glog"
+
+	"github.com/kubernetes-csi/drivers/pkg/csi-common"
 )
 
 const (
@@ -40,6 +42,7 @@ type IMount interface {
 	ScanForAttach(devicePath string) error
 	IsLikelyNotMountPointAttach(targetpath string) (bool, error)
 	FormatAndMount(source string, target string, fstype string, options []string) error
+	MountBlock(source string, target string, readOnly bool) error
 	IsLikelyNotMountPointDetach(targetpath string) (bool, error)
 	UnmountPath(mountPath string) error
 	GetInstanceID() (string, error)
@@ -113,6 +116,11 @@ func (m *Mount) FormatAndMount(source string, target string, fstype string, opti
 	return diskMounter.FormatAndMount(source, target, fstype, options)
 }
 
+// MountBlock bind mounts the device on the target file
+func (m *Mount) MountBlock(source string, target string, readOnly bool) error {
+	return csicommon.NewMounter().MountBlock(source, target, readOnly)
+}
+
 // IsLikelyNotMountPointAttach
 func (m *Mount) IsLikelyNotMountPointAttach(targetpath string) (bool, error) {
 	notMnt, err := mount.New("").IsLikelyNotMountPoint(targetpath)
@@ -101,6 +101,20 @@ func (_m *MountMock) IsLikelyNotMountPointDetach(targetpath string) (bool, error
 	return r0, r1
 }
 
+// MountBlock provides a mock function with given fields: source, target, readOnly
+func (_m *MountMock) MountBlock(source string, target string, readOnly bool) error {
+	ret := _m.Called(source, target, readOnly)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(string, string, bool) error); ok {
+		r0 = rf(source, target, readOnly)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
 // ScanForAttach provides a mock function with given fields: devicePath
 func (_m *MountMock) ScanForAttach(devicePath string) error {
 	ret := _m.Called(devicePath)
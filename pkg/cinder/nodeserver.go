@@ -29,6 +29,7 @@ import (
 
 type nodeServer struct {
 	*csicommon.DefaultNodeServer
+	volumeLocks *csicommon.VolumeLocks
 }
 
 func (ns *nodeServer) NodeGetId(ctx context.Context, req *csi.NodeGetIdRequest) (*csi.NodeGetIdResponse, error) {
@@ -61,6 +62,15 @@ func (ns *nodeServer) NodePublishVolume(ctx context.Context, req *csi.NodePublis
 	targetPath := req.GetTargetPath()
 	fsType := req.GetVolumeCapability().GetMount().GetFsType()
 	devicePath := req.GetPublishInfo()["DevicePath"]
+	if req.GetVolumeCapability() != nil {
+		if err := ns.Driver.ValidateVolumeCapability(req.GetVolumeCapability()); err != nil {
+			return nil, err
+		}
+	}
+	if err := ns.volumeLocks.Acquire(targetPath); err != nil {
+		return nil, err
+	}
+	defer ns.volumeLocks.Release(targetPath)
 
 	// Get Mount Provider
 	m, err := mount.GetMountProvider()
@@ -76,6 +86,14 @@ func (ns *nodeServer) NodePublishVolume(ctx context.Context, req *csi.NodePublis
 		return nil, err
 	}
 
+	// Block volume
+	if req.GetVolumeCapability().GetBlock() != nil {
+		if err := m.MountBlock(devicePath, targetPath, req.GetReadonly()); err != nil {
+			return nil, err
+		}
+		return &csi.NodePublishVolumeResponse{}, nil
+	}
+
 	// Verify whether mounted
 	notMnt, err := m.IsLikelyNotMountPointAttach(targetPath)
 	if err != nil {
@@ -107,6 +125,10 @@ func (ns *nodeServer) NodePublishVolume(ctx context.Context, req *csi.NodePublis
 func (ns *nodeServer) NodeUnpublishVolume(ctx context.Context, req *csi.NodeUnpublishVolumeRequest) (*csi.NodeUnpublishVolumeResponse, error) {
 
 	targetPath := req.GetTargetPath()
+	if err := ns.volumeLocks.Acquire(targetPath); err != nil {
+		return nil, err
+	}
+	defer ns.volumeLocks.Release(targetPath)
 
 	// Get Mount Provider
 	m, err := mount.GetMountProvider()
@@ -103,6 +103,50 @@ func TestNodePublishVolume(t *testing.T) {
 	assert.Equal(expectedRes, actualRes)
 }
 
+// Test NodePublishVolume with a block volume
+func TestNodePublishVolumeBlock(t *testing.T) {
+
+	// mock MountMock
+	mmock := new(mount.MountMock)
+	// ScanForAttach(devicePath string) error
+	mmock.On("ScanForAttach", fakeDevicePath).Return(nil)
+	// MountBlock(source string, target string, readOnly bool) error
+	mmock.On("MountBlock", fakeDevicePath, fakeTargetPath, true).Return(nil)
+	mount.MInstance = mmock
+
+	// Init assert
+	assert := assert.New(t)
+
+	// Expected Result
+	expectedRes := &csi.NodePublishVolumeResponse{}
+
+	// Fake request
+	fakeReq := &csi.NodePublishVolumeRequest{
+		VolumeId:    fakeVolID,
+		PublishInfo: map[string]string{"DevicePath": fakeDevicePath},
+		TargetPath:  fakeTargetPath,
+		VolumeCapability: &csi.VolumeCapability{
+			AccessType: &csi.VolumeCapability_Block{
+				Block: &csi.VolumeCapability_BlockVolume{},
+			},
+			AccessMode: &csi.VolumeCapability_AccessMode{
+				Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER,
+			},
+		},
+		Readonly: true,
+	}
+
+	// Invoke NodePublishVolume
+	actualRes, err := fakeNs.NodePublishVolume(fakeCtx, fakeReq)
+	if err != nil {
+		t.Errorf("failed to NodePublishVolume: %v", err)
+	}
+
+	// Assert
+	assert.Equal(expectedRes, actualRes)
+	mmock.AssertExpectations(t)
+}
+
 // Test NodeUnpublishVolume
 func TestNodeUnpublishVolume(t *testing.T) {
 
@@ -17,27 +17,47 @@ limitations under the License.
 package openstack
 
 import (
+	"fmt"
 	"os"
+	"sync"
 
 	"github.com/golang/glog"
 	"github.com/gophercloud/gophercloud"
 	"github.com/gophercloud/gophercloud/openstack"
+	"github.com/gophercloud/gophercloud/openstack/identity/v3/tokens"
+	"github.com/gophercloud/gophercloud/openstack/utils"
 	"gopkg.in/gcfg.v1"
 )
 
 type IOpenStack interface {
-	CreateVolume(name string, size int, vtype, availability string, tags *map[string]string) (string, string, error)
+	CreateVolume(name string, size int, vtype, availability string, snapshotID string, tags *map[string]string) (string, string, error)
 	DeleteVolume(volumeID string) error
+	GetVolumesByName(name string) ([]Volume, error)
+	WaitVolumeReady(volumeID string) error
 	AttachVolume(instanceID, volumeID string) (string, error)
 	WaitDiskAttached(instanceID string, volumeID string) error
 	DetachVolume(instanceID, volumeID string) error
 	WaitDiskDetached(instanceID string, volumeID string) error
 	GetAttachmentDiskPath(instanceID, volumeID string) (string, error)
+	CreateSnapshot(name, volumeID, description string, tags *map[string]string) (Snapshot, error)
+	ListSnapshots(filters map[string]string) ([]Snapshot, error)
+	DeleteSnapshot(snapshotID string) error
+	GetSnapshotByID(snapshotID string) (Snapshot, error)
+	WaitSnapshotReady(snapshotID string) error
+	CheckToken() error
 }
 
 type OpenStack struct {
 	compute      *gophercloud.ServiceClient
 	blockstorage *gophercloud.ServiceClient
+	provider     *gophercloud.ProviderClient
+	epOpts       gophercloud.EndpointOpts
+
+	// identity is created by the first CheckToken, so that clouds without
+	// a Keystone v3 endpoint can still manage volumes.
+	identityLock sync.Mutex
+	identity     *gophercloud.ServiceClient
+	identityV2   bool
 }
 
 type Config struct {
@@ -156,8 +176,64 @@ func GetOpenStackProvider() (IOpenStack, error) {
 		OsInstance = &OpenStack{
 			compute:      computeclient,
 			blockstorage: blockstorageclient,
+			provider:     provider,
+			epOpts:       epOpts,
 		}
 	}
 
 	return OsInstance, nil
 }
+
+// identityClient returns the Keystone v3 client, or nil when the provider
+// authenticated against Keystone v2.
+func (os *OpenStack) identityClient() (*gophercloud.ServiceClient, error) {
+	os.identityLock.Lock()
+	defer os.identityLock.Unlock()
+
+	if os.identity != nil || os.identityV2 {
+		return os.identity, nil
+	}
+
+	// Same choice as the one made by openstack.AuthenticatedClient
+	chosen, _, err := utils.ChooseVersion(os.provider, []*utils.Version{
+		{ID: "v2.0", Priority: 20, Suffix: "/v2.0/"},
+		{ID: "v3.0", Priority: 30, Suffix: "/v3/"},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to find the identity version: %v", err)
+	}
+	if chosen.ID == "v2.0" {
+		os.identityV2 = true
+		return nil, nil
+	}
+
+	identity, err := openstack.NewIdentityV3(os.provider, os.epOpts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to init identity client: %v", err)
+	}
+	os.identity = identity
+	return identity, nil
+}
+
+// CheckToken verifies that the token of the provider is still accepted by
+// Keystone. Keystone v2 tokens are not checked.
+func (os *OpenStack) CheckToken() error {
+	identity, err := os.identityClient()
+	if err != nil {
+		return err
+	}
+	if identity == nil {
+		glog.V(5).Infof("Not validating Keystone v2 token")
+		return nil
+	}
+
+	mc := newMetricContext("token_validate")
+	valid, err := tokens.Validate(identity, identity.Token())
+	if mc.observe(err) != nil {
+		return fmt.Errorf("failed to validate token: %v", err)
+	}
+	if !valid {
+		return fmt.Errorf("token is not valid")
+	}
+	return nil
+}
@@ -0,0 +1,72 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package openstack
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/kubernetes-csi/drivers/pkg/csi-common"
+)
+
+var (
+	openstackRequestDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: csicommon.MetricsNamespace,
+			Subsystem: "cinder",
+			Name:      "api_request_duration_seconds",
+			Help:      "Latency of OpenStack API calls by request.",
+		},
+		[]string{"request"},
+	)
+
+	openstackRequestErrors = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: csicommon.MetricsNamespace,
+			Subsystem: "cinder",
+			Name:      "api_request_errors_total",
+			Help:      "Number of failed OpenStack API calls by request.",
+		},
+		[]string{"request"},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(openstackRequestDuration, openstackRequestErrors)
+}
+
+type metricContext struct {
+	start   time.Time
+	request string
+}
+
+func newMetricContext(request string) *metricContext {
+	return &metricContext{
+		start:   time.Now(),
+		request: request,
+	}
+}
+
+// observe records the outcome of the request and passes err through.
+func (mc *metricContext) observe(err error) error {
+	openstackRequestDuration.WithLabelValues(mc.request).Observe(time.Since(mc.start).Seconds())
+	if err != nil {
+		openstackRequestErrors.WithLabelValues(mc.request).Inc()
+	}
+	return err
+}
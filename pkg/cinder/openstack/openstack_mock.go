@@ -45,27 +45,41 @@ func (_m *OpenStackMock) AttachVolume(instanceID string, volumeID string) (strin
 	return r0, r1
 }
 
-// CreateVolume provides a mock function with given fields: name, size, vtype, availability, tags
-func (_m *OpenStackMock) CreateVolume(name string, size int, vtype string, availability string, tags *map[string]string) (string, string, error) {
-	ret := _m.Called(name, size, vtype, availability, tags)
+// CheckToken provides a mock function with given fields:
+func (_m *OpenStackMock) CheckToken() error {
+	ret := _m.Called()
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func() error); ok {
+		r0 = rf()
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// CreateVolume provides a mock function with given fields: name, size, vtype, availability, snapshotID, tags
+func (_m *OpenStackMock) CreateVolume(name string, size int, vtype string, availability string, snapshotID string, tags *map[string]string) (string, string, error) {
+	ret := _m.Called(name, size, vtype, availability, snapshotID, tags)
 
 	var r0 string
-	if rf, ok := ret.Get(0).(func(string, int, string, string, *map[string]string) string); ok {
-		r0 = rf(name, size, vtype, availability, tags)
+	if rf, ok := ret.Get(0).(func(string, int, string, string, string, *map[string]string) string); ok {
+		r0 = rf(name, size, vtype, availability, snapshotID, tags)
 	} else {
 		r0 = ret.Get(0).(string)
 	}
 
 	var r1 string
-	if rf, ok := ret.Get(1).(func(string, int, string, string, *map[string]string) string); ok {
-		r1 = rf(name, size, vtype, availability, tags)
+	if rf, ok := ret.Get(1).(func(string, int, string, string, string, *map[string]string) string); ok {
+		r1 = rf(name, size, vtype, availability, snapshotID, tags)
 	} else {
 		r1 = ret.Get(1).(string)
 	}
 
 	var r2 error
-	if rf, ok := ret.Get(2).(func(string, int, string, string, *map[string]string) error); ok {
-		r2 = rf(name, size, vtype, availability, tags)
+	if rf, ok := ret.Get(2).(func(string, int, string, string, string, *map[string]string) error); ok {
+		r2 = rf(name, size, vtype, availability, snapshotID, tags)
 	} else {
 		r2 = ret.Error(2)
 	}
@@ -73,6 +87,27 @@ func (_m *OpenStackMock) CreateVolume(name string, size int, vtype string, avail
 	return r0, r1, r2
 }
 
+// CreateSnapshot provides a mock function with given fields: name, volumeID, description, tags
+func (_m *OpenStackMock) CreateSnapshot(name string, volumeID string, description string, tags *map[string]string) (Snapshot, error) {
+	ret := _m.Called(name, volumeID, description, tags)
+
+	var r0 Snapshot
+	if rf, ok := ret.Get(0).(func(string, string, string, *map[string]string) Snapshot); ok {
+		r0 = rf(name, volumeID, description, tags)
+	} else {
+		r0 = ret.Get(0).(Snapshot)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(string, string, string, *map[string]string) error); ok {
+		r1 = rf(name, volumeID, description, tags)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 // DeleteVolume provides a mock function with given fields: volumeID
 func (_m *OpenStackMock) DeleteVolume(volumeID string) error {
 	ret := _m.Called(volumeID)
@@ -87,6 +122,20 @@ func (_m *OpenStackMock) DeleteVolume(volumeID string) error {
 	return r0
 }
 
+// DeleteSnapshot provides a mock function with given fields: snapshotID
+func (_m *OpenStackMock) DeleteSnapshot(snapshotID string) error {
+	ret := _m.Called(snapshotID)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(string) error); ok {
+		r0 = rf(snapshotID)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
 // DetachVolume provides a mock function with given fields: instanceID, volumeID
 func (_m *OpenStackMock) DetachVolume(instanceID string, volumeID string) error {
 	ret := _m.Called(instanceID, volumeID)
@@ -122,6 +171,69 @@ func (_m *OpenStackMock) GetAttachmentDiskPath(instanceID string, volumeID strin
 	return r0, r1
 }
 
+// GetSnapshotByID provides a mock function with given fields: snapshotID
+func (_m *OpenStackMock) GetSnapshotByID(snapshotID string) (Snapshot, error) {
+	ret := _m.Called(snapshotID)
+
+	var r0 Snapshot
+	if rf, ok := ret.Get(0).(func(string) Snapshot); ok {
+		r0 = rf(snapshotID)
+	} else {
+		r0 = ret.Get(0).(Snapshot)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(string) error); ok {
+		r1 = rf(snapshotID)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// GetVolumesByName provides a mock function with given fields: name
+func (_m *OpenStackMock) GetVolumesByName(name string) ([]Volume, error) {
+	ret := _m.Called(name)
+
+	var r0 []Volume
+	if rf, ok := ret.Get(0).(func(string) []Volume); ok {
+		r0 = rf(name)
+	} else if ret.Get(0) != nil {
+		r0 = ret.Get(0).([]Volume)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(string) error); ok {
+		r1 = rf(name)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// ListSnapshots provides a mock function with given fields: filters
+func (_m *OpenStackMock) ListSnapshots(filters map[string]string) ([]Snapshot, error) {
+	ret := _m.Called(filters)
+
+	var r0 []Snapshot
+	if rf, ok := ret.Get(0).(func(map[string]string) []Snapshot); ok {
+		r0 = rf(filters)
+	} else if ret.Get(0) != nil {
+		r0 = ret.Get(0).([]Snapshot)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(map[string]string) error); ok {
+		r1 = rf(filters)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 // WaitDiskAttached provides a mock function with given fields: instanceID, volumeID
 func (_m *OpenStackMock) WaitDiskAttached(instanceID string, volumeID string) error {
 	ret := _m.Called(instanceID, volumeID)
@@ -149,3 +261,31 @@ func (_m *OpenStackMock) WaitDiskDetached(instanceID string, volumeID string) er
 
 	return r0
 }
+
+// WaitSnapshotReady provides a mock function with given fields: snapshotID
+func (_m *OpenStackMock) WaitSnapshotReady(snapshotID string) error {
+	ret := _m.Called(snapshotID)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(string) error); ok {
+		r0 = rf(snapshotID)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// WaitVolumeReady provides a mock function with given fields: volumeID
+func (_m *OpenStackMock) WaitVolumeReady(volumeID string) error {
+	ret := _m.Called(volumeID)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(string) error); ok {
+		r0 = rf(volumeID)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
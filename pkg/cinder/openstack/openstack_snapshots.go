@@ -0,0 +1,156 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package openstack
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/gophercloud/gophercloud/openstack/blockstorage/v3/snapshots"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+const (
+	SnapshotReadyStatus    = "available"
+	SnapshotErrorStatus    = "error"
+	snapshotReadyInitDelay = 1 * time.Second
+	snapshotReadyFactor    = 1.2
+	snapshotReadySteps     = 10
+)
+
+type Snapshot struct {
+	// Unique identifier for the snapshot.
+	ID string
+	// Human-readable display name for the snapshot.
+	Name string
+	// ID of the volume the snapshot was taken of.
+	VolumeID string
+	// Current status of the snapshot.
+	Status string
+	// Snapshot size in GB
+	Size int
+}
+
+func toSnapshot(snap *snapshots.Snapshot) Snapshot {
+	return Snapshot{
+		ID:       snap.ID,
+		Name:     snap.Name,
+		VolumeID: snap.VolumeID,
+		Status:   snap.Status,
+		Size:     snap.Size,
+	}
+}
+
+// CreateSnapshot creates a snapshot of the given volume
+func (os *OpenStack) CreateSnapshot(name, volumeID, description string, tags *map[string]string) (Snapshot, error) {
+	opts := &snapshots.CreateOpts{
+		VolumeID:    volumeID,
+		Name:        name,
+		Description: description,
+		// Snapshot volumes attached to a compute as well
+		Force: true,
+	}
+	if tags != nil {
+		opts.Metadata = *tags
+	}
+
+	mc := newMetricContext("snapshot_create")
+	snap, err := snapshots.Create(os.blockstorage, opts).Extract()
+	if mc.observe(err) != nil {
+		return Snapshot{}, err
+	}
+
+	return toSnapshot(snap), nil
+}
+
+// ListSnapshots lists the snapshots matching the filters, whose keys are
+// Name, VolumeID and Status
+func (os *OpenStack) ListSnapshots(filters map[string]string) ([]Snapshot, error) {
+	opts := snapshots.ListOpts{}
+	for key, value := range filters {
+		switch key {
+		case "Name":
+			opts.Name = value
+		case "VolumeID":
+			opts.VolumeID = value
+		case "Status":
+			opts.Status = value
+		default:
+			return nil, fmt.Errorf("unknown snapshot filter %q", key)
+		}
+	}
+
+	mc := newMetricContext("snapshot_list")
+	pages, err := snapshots.List(os.blockstorage, opts).AllPages()
+	if mc.observe(err) != nil {
+		return nil, err
+	}
+	snaps, err := snapshots.ExtractSnapshots(pages)
+	if err != nil {
+		return nil, err
+	}
+
+	var result []Snapshot
+	for i := range snaps {
+		result = append(result, toSnapshot(&snaps[i]))
+	}
+	return result, nil
+}
+
+// DeleteSnapshot deletes a snapshot
+func (os *OpenStack) DeleteSnapshot(snapshotID string) error {
+	mc := newMetricContext("snapshot_delete")
+	err := snapshots.Delete(os.blockstorage, snapshotID).ExtractErr()
+	return mc.observe(err)
+}
+
+// GetSnapshotByID retrieves a snapshot by its ID
+func (os *OpenStack) GetSnapshotByID(snapshotID string) (Snapshot, error) {
+	mc := newMetricContext("snapshot_get")
+	snap, err := snapshots.Get(os.blockstorage, snapshotID).Extract()
+	if mc.observe(err) != nil {
+		return Snapshot{}, err
+	}
+
+	return toSnapshot(snap), nil
+}
+
+// WaitSnapshotReady waits for the snapshot to be available
+func (os *OpenStack) WaitSnapshotReady(snapshotID string) error {
+	backoff := wait.Backoff{
+		Duration: snapshotReadyInitDelay,
+		Factor:   snapshotReadyFactor,
+		Steps:    snapshotReadySteps,
+	}
+
+	err := wait.ExponentialBackoff(backoff, func() (bool, error) {
+		snap, err := os.GetSnapshotByID(snapshotID)
+		if err != nil {
+			return false, err
+		}
+		if snap.Status == SnapshotErrorStatus {
+			return false, fmt.Errorf("snapshot %q is in error state", snapshotID)
+		}
+		return snap.Status == SnapshotReadyStatus, nil
+	})
+
+	if err == wait.ErrWaitTimeout {
+		err = fmt.Errorf("Snapshot %q failed to become ready within the alloted time", snapshotID)
+	}
+
+	return err
+}
@@ -17,6 +17,8 @@ limitations under the License.
 package openstack
 
 import (
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"testing"
 
@@ -117,3 +119,45 @@ func TestGetConfigFromEnv(t *testing.T) {
 	assert.Equal(expectedAuthOpts, actualAuthOpts)
 	assert.Equal(expectedEpOpts, actualEpOpts)
 }
+
+// Test CheckToken against Keystone v3
+func TestCheckToken(t *testing.T) {
+	var requests []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.Method+" "+r.URL.Path)
+		if r.Header.Get("X-Subject-Token") == "valid" {
+			w.WriteHeader(http.StatusNoContent)
+		} else {
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	provider := &gophercloud.ProviderClient{
+		IdentityBase:     srv.URL + "/",
+		IdentityEndpoint: srv.URL + "/v3/",
+		TokenID:          "valid",
+	}
+	cloud := &OpenStack{provider: provider}
+
+	assert.NoError(t, cloud.CheckToken())
+	provider.TokenID = "expired"
+	assert.Error(t, cloud.CheckToken())
+	assert.Equal(t, []string{"HEAD /v3/auth/tokens", "HEAD /v3/auth/tokens"}, requests)
+}
+
+// Test CheckToken does not validate Keystone v2 tokens against v3
+func TestCheckTokenV2(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+	}))
+	defer srv.Close()
+
+	cloud := &OpenStack{provider: &gophercloud.ProviderClient{
+		IdentityBase:     srv.URL + "/",
+		IdentityEndpoint: srv.URL + "/v2.0/",
+		TokenID:          "valid",
+	}}
+
+	assert.NoError(t, cloud.CheckToken())
+}
@@ -20,6 +20,7 @@ import (
 	"fmt"
 	"time"
 
+	"github.com/gophercloud/gophercloud"
 	"github.com/gophercloud/gophercloud/openstack/blockstorage/v3/volumes"
 	"github.com/gophercloud/gophercloud/openstack/compute/v2/extensions/volumeattach"
 	"k8s.io/apimachinery/pkg/util/wait"
@@ -43,6 +44,10 @@ const (
 	diskDetachSteps          = 13
 )
 
+// VolumeNameKey is the metadata key recording the name a volume was created
+// with, so that it can be found again regardless of its display name
+const VolumeNameKey = "csi.volume.name"
+
 type Volume struct {
 	// ID of the instance, to which this volume is attached. "" if not attached
 	AttachedServerId string
@@ -56,22 +61,75 @@ type Volume struct {
 	Status string
 	// Volume size in GB
 	Size int
+	// Volume type the volume was created with
+	VolumeType string
+	// Availability zone of the volume
+	AvailabilityZone string
+	// Snapshot the volume was restored from, "" if none
+	SnapshotID string
+}
+
+func toVolume(vol *volumes.Volume) Volume {
+	volume := Volume{
+		ID:               vol.ID,
+		Name:             vol.Name,
+		Status:           vol.Status,
+		Size:             vol.Size,
+		VolumeType:       vol.VolumeType,
+		AvailabilityZone: vol.AvailabilityZone,
+		SnapshotID:       vol.SnapshotID,
+	}
+
+	if len(vol.Attachments) > 0 {
+		volume.AttachedServerId = vol.Attachments[0].ServerID
+		volume.AttachedDevice = vol.Attachments[0].Device
+	}
+
+	return volume
+}
+
+// VolumeFailedError is returned when a volume lands in error state
+type VolumeFailedError struct {
+	VolumeID string
 }
 
-// CreateVolume creates a volume of given size
-func (os *OpenStack) CreateVolume(name string, size int, vtype, availability string, tags *map[string]string) (string, string, error) {
+func (e VolumeFailedError) Error() string {
+	return fmt.Sprintf("volume %q is in error state", e.VolumeID)
+}
+
+// IsVolumeFailed returns true if err reports a volume in error state
+func IsVolumeFailed(err error) bool {
+	_, ok := err.(VolumeFailedError)
+	return ok
+}
+
+// IsNotFound returns true if err is a 404 returned by OpenStack
+func IsNotFound(err error) bool {
+	_, ok := err.(gophercloud.ErrDefault404)
+	return ok
+}
+
+// CreateVolume creates a volume of given size, restoring the snapshot
+// snapshotID unless empty. The name is recorded under VolumeNameKey.
+func (os *OpenStack) CreateVolume(name string, size int, vtype, availability string, snapshotID string, tags *map[string]string) (string, string, error) {
 	opts := &volumes.CreateOpts{
 		Name:             name,
 		Size:             size,
 		VolumeType:       vtype,
 		AvailabilityZone: availability,
+		SnapshotID:       snapshotID,
+		Metadata:         map[string]string{},
 	}
 	if tags != nil {
-		opts.Metadata = *tags
+		for key, value := range *tags {
+			opts.Metadata[key] = value
+		}
 	}
+	opts.Metadata[VolumeNameKey] = name
 
+	mc := newMetricContext("volume_create")
 	vol, err := volumes.Create(os.blockstorage, opts).Extract()
-	if err != nil {
+	if mc.observe(err) != nil {
 		return "", "", err
 	}
 
@@ -88,30 +146,73 @@ func (os *OpenStack) DeleteVolume(volumeID string) error {
 		return fmt.Errorf("Cannot delete the volume %q, it's still attached to a node", volumeID)
 	}
 
+	mc := newMetricContext("volume_delete")
 	err = volumes.Delete(os.blockstorage, volumeID).ExtractErr()
-	return err
+	return mc.observe(err)
 }
 
 // GetVolume retrieves Volume by its ID.
 func (os *OpenStack) GetVolume(volumeID string) (Volume, error) {
-
+	mc := newMetricContext("volume_get")
 	vol, err := volumes.Get(os.blockstorage, volumeID).Extract()
-	if err != nil {
+	if mc.observe(err) != nil {
 		return Volume{}, err
 	}
 
-	volume := Volume{
-		ID:     vol.ID,
-		Name:   vol.Name,
-		Status: vol.Status,
+	return toVolume(vol), nil
+}
+
+// GetVolumesByName retrieves the volumes created with the given name
+func (os *OpenStack) GetVolumesByName(name string) ([]Volume, error) {
+	opts := volumes.ListOpts{
+		Metadata: map[string]string{VolumeNameKey: name},
 	}
 
-	if len(vol.Attachments) > 0 {
-		volume.AttachedServerId = vol.Attachments[0].ServerID
-		volume.AttachedDevice = vol.Attachments[0].Device
+	mc := newMetricContext("volume_list")
+	pages, err := volumes.List(os.blockstorage, opts).AllPages()
+	if mc.observe(err) != nil {
+		return nil, err
+	}
+	vols, err := volumes.ExtractVolumes(pages)
+	if err != nil {
+		return nil, err
+	}
+
+	var result []Volume
+	for i := range vols {
+		// Do not rely on the server honouring the metadata filter
+		if vols[i].Metadata[VolumeNameKey] != name {
+			continue
+		}
+		result = append(result, toVolume(&vols[i]))
+	}
+	return result, nil
+}
+
+// WaitVolumeReady waits for the volume to be available
+func (os *OpenStack) WaitVolumeReady(volumeID string) error {
+	backoff := wait.Backoff{
+		Duration: operationFinishInitDelay,
+		Factor:   operationFinishFactor,
+		Steps:    operationFinishSteps,
 	}
 
-	return volume, nil
+	err := wait.ExponentialBackoff(backoff, func() (bool, error) {
+		volume, err := os.GetVolume(volumeID)
+		if err != nil {
+			return false, err
+		}
+		if volume.Status == VolumeErrorStatus {
+			return false, VolumeFailedError{volumeID}
+		}
+		return volume.Status == VolumeAvailableStatus, nil
+	})
+
+	if err == wait.ErrWaitTimeout {
+		err = fmt.Errorf("Volume %q failed to become available within the alloted time", volumeID)
+	}
+
+	return err
 }
 
 // AttachVolume attaches given cinder volume to the compute
@@ -129,11 +230,12 @@ func (os *OpenStack) AttachVolume(instanceID, volumeID string) (string, error) {
 		return "", fmt.Errorf("disk %s is attached to a different instance (%s)", volumeID, volume.AttachedServerId)
 	}
 
+	mc := newMetricContext("volume_attach")
 	_, err = volumeattach.Create(os.compute, instanceID, &volumeattach.CreateOpts{
 		VolumeID: volume.ID,
 	}).Extract()
 
-	if err != nil {
+	if mc.observe(err) != nil {
 		return "", fmt.Errorf("failed to attach %s volume to %s compute: %v", volumeID, instanceID, err)
 	}
 	glog.V(2).Infof("Successfully attached %s volume to %s compute", volumeID, instanceID)
@@ -181,8 +283,9 @@ func (os *OpenStack) DetachVolume(instanceID, volumeID string) error {
 	if volume.AttachedServerId != instanceID {
 		return fmt.Errorf("disk: %s has no attachments or is not attached to compute: %s", volume.Name, instanceID)
 	} else {
+		mc := newMetricContext("volume_detach")
 		err = volumeattach.Delete(os.compute, instanceID, volume.ID).ExtractErr()
-		if err != nil {
+		if mc.observe(err) != nil {
 			return fmt.Errorf("failed to delete volume %s from compute %s attached %v", volume.ID, instanceID, err)
 		}
 		glog.V(2).Infof("Successfully detached volume: %s from compute: %s", volume.ID, instanceID)
@@ -60,7 +60,12 @@ func (cs *DefaultControllerServer) ValidateVolumeCapabilities(ctx context.Contex
 				Message:   "Driver doesnot support mode:" + c.GetAccessMode().GetMode().String(),
 			}, status.Error(codes.InvalidArgument, "Driver doesnot support mode:"+c.GetAccessMode().GetMode().String())
 		}
-		// TODO: Ignoring mount & block tyeps for now.
+		if c.GetBlock() != nil && !cs.Driver.block {
+			return &csi.ValidateVolumeCapabilitiesResponse{
+				Supported: false,
+				Message:   "Driver does not support block volumes",
+			}, nil
+		}
 	}
 
 	return &csi.ValidateVolumeCapabilitiesResponse{
@@ -0,0 +1,338 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package csitest checks CSI servers against the spec from go test. The
+// servers are served in-process over an in-memory connection, so neither a
+// socket nor an external sanity binary is needed:
+//
+//	func TestConformance(t *testing.T) {
+//		csitest.Run(t, csitest.Config{
+//			Identity:   ids,
+//			Controller: cs,
+//			Node:       ns,
+//		})
+//	}
+package csitest
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/container-storage-interface/spec/lib/go/csi/v0"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+
+	"github.com/kubernetes-csi/drivers/pkg/csi-common"
+)
+
+const bufSize = 1024 * 1024
+
+// Config describes the servers under test.
+type Config struct {
+	Identity csi.IdentityServer
+	// Controller and Node are optional, the scenarios needing a missing
+	// server are skipped.
+	Controller csi.ControllerServer
+	Node       csi.NodeServer
+
+	// Parameters passed to CreateVolume.
+	Parameters map[string]string
+	// Capability of the volumes, a SINGLE_NODE_WRITER mount by default.
+	VolumeCapability *csi.VolumeCapability
+
+	// Existing volume used by the node scenarios of drivers which cannot
+	// create volumes, see NodePublishVolumeRequest.
+	VolumeID         string
+	VolumeAttributes map[string]string
+
+	// Directory under which the staging and target paths are created, a
+	// temporary directory by default.
+	TargetDir string
+
+	// Names of the scenarios to skip, e.g. the ones mounting volumes when
+	// the test runs unprivileged.
+	Skip []string
+}
+
+type scenario struct {
+	name string
+	// requires reports why the scenario does not apply, "" if it does.
+	requires func(h *harness) string
+	run      func(t *testing.T, h *harness)
+}
+
+// Scenarios lists the names of the available scenarios.
+func Scenarios() []string {
+	var names []string
+	for _, s := range scenarios {
+		names = append(names, s.name)
+	}
+	return names
+}
+
+var scenarios = []scenario{
+	{"Identity/GetPluginInfo", nil, testGetPluginInfo},
+	{"Identity/Probe", nil, testProbe},
+	{"Identity/GetPluginCapabilities", nil, testGetPluginCapabilities},
+	{"Controller/ControllerGetCapabilities", needsController, testControllerGetCapabilities},
+	{"Controller/CreateVolumeMissingArguments", needsControllerCap(csi.ControllerServiceCapability_RPC_CREATE_DELETE_VOLUME), testCreateVolumeMissingArguments},
+	{"Controller/CreateVolumeIdempotent", needsControllerCap(csi.ControllerServiceCapability_RPC_CREATE_DELETE_VOLUME), testCreateVolumeIdempotent},
+	{"Controller/CreateVolumeNotSupported", needsNoControllerCap(csi.ControllerServiceCapability_RPC_CREATE_DELETE_VOLUME), testCreateVolumeNotSupported},
+	{"Controller/DeleteVolumeMissingArguments", needsControllerCap(csi.ControllerServiceCapability_RPC_CREATE_DELETE_VOLUME), testDeleteVolumeMissingArguments},
+	{"Controller/DeleteVolumeIdempotent", needsControllerCap(csi.ControllerServiceCapability_RPC_CREATE_DELETE_VOLUME), testDeleteVolumeIdempotent},
+	{"Controller/ValidateVolumeCapabilitiesMissingArguments", needsController, testValidateVolumeCapabilitiesMissingArguments},
+	{"Controller/ValidateVolumeCapabilities", needsControllerCap(csi.ControllerServiceCapability_RPC_CREATE_DELETE_VOLUME), testValidateVolumeCapabilities},
+	{"Controller/PublishMissingArguments", needsControllerCap(csi.ControllerServiceCapability_RPC_PUBLISH_UNPUBLISH_VOLUME), testControllerPublishMissingArguments},
+	{"Controller/PublishUnpublish", needsControllerCap(csi.ControllerServiceCapability_RPC_CREATE_DELETE_VOLUME, csi.ControllerServiceCapability_RPC_PUBLISH_UNPUBLISH_VOLUME), testControllerPublishUnpublish},
+	{"Node/NodeGetId", needsNode, testNodeGetId},
+	{"Node/NodeGetCapabilities", needsNode, testNodeGetCapabilities},
+	{"Node/StageMissingArguments", needsNodeCap(csi.NodeServiceCapability_RPC_STAGE_UNSTAGE_VOLUME), testNodeStageMissingArguments},
+	{"Node/PublishMissingArguments", needsNode, testNodePublishMissingArguments},
+	{"Node/UnpublishMissingArguments", needsNode, testNodeUnpublishMissingArguments},
+	{"Node/PublishUnpublish", needsVolume, testNodePublishUnpublish},
+}
+
+// Run serves the servers of config and runs the applicable scenarios as
+// subtests of t.
+func Run(t *testing.T, config Config) {
+	h := newHarness(t, config)
+	defer h.close()
+
+	skip := map[string]bool{}
+	for _, name := range config.Skip {
+		skip[name] = true
+	}
+
+	for _, s := range scenarios {
+		s := s
+		t.Run(s.name, func(t *testing.T) {
+			if skip[s.name] {
+				t.Skip("skipped by the configuration")
+			}
+			if s.requires != nil {
+				if reason := s.requires(h); reason != "" {
+					t.Skip(reason)
+				}
+			}
+			s.run(t, h)
+		})
+	}
+}
+
+type harness struct {
+	config Config
+
+	server    *grpc.Server
+	conn      *grpc.ClientConn
+	targetDir string
+	tempDir   bool
+
+	ids csi.IdentityClient
+	cs  csi.ControllerClient
+	ns  csi.NodeClient
+
+	controllerCaps map[csi.ControllerServiceCapability_RPC_Type]bool
+	nodeCaps       map[csi.NodeServiceCapability_RPC_Type]bool
+}
+
+func newHarness(t *testing.T, config Config) *harness {
+	if config.Identity == nil {
+		t.Fatalf("an identity server is required")
+	}
+	if config.VolumeCapability == nil {
+		config.VolumeCapability = &csi.VolumeCapability{
+			AccessType: &csi.VolumeCapability_Mount{
+				Mount: &csi.VolumeCapability_MountVolume{},
+			},
+			AccessMode: &csi.VolumeCapability_AccessMode{
+				Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER,
+			},
+		}
+	}
+
+	h := &harness{
+		config:         config,
+		targetDir:      config.TargetDir,
+		controllerCaps: map[csi.ControllerServiceCapability_RPC_Type]bool{},
+		nodeCaps:       map[csi.NodeServiceCapability_RPC_Type]bool{},
+	}
+	if h.targetDir == "" {
+		dir, err := ioutil.TempDir("", "csitest")
+		if err != nil {
+			t.Fatalf("failed to create target directory: %v", err)
+		}
+		h.targetDir = dir
+		h.tempDir = true
+	}
+
+	listener := bufconn.Listen(bufSize)
+	h.server = grpc.NewServer()
+	csicommon.RegisterServers(h.server, config.Identity, config.Controller, config.Node)
+	go h.server.Serve(listener)
+
+	conn, err := grpc.Dial("bufconn", grpc.WithInsecure(), grpc.WithDialer(func(string, time.Duration) (net.Conn, error) {
+		return listener.Dial()
+	}))
+	if err != nil {
+		h.close()
+		t.Fatalf("failed to connect to the servers: %v", err)
+	}
+	h.conn = conn
+	h.ids = csi.NewIdentityClient(conn)
+	h.cs = csi.NewControllerClient(conn)
+	h.ns = csi.NewNodeClient(conn)
+
+	if config.Controller != nil {
+		resp, err := h.cs.ControllerGetCapabilities(context.Background(), &csi.ControllerGetCapabilitiesRequest{})
+		if err != nil {
+			h.close()
+			t.Fatalf("ControllerGetCapabilities failed: %v", err)
+		}
+		for _, c := range resp.GetCapabilities() {
+			h.controllerCaps[c.GetRpc().GetType()] = true
+		}
+	}
+	if config.Node != nil {
+		resp, err := h.ns.NodeGetCapabilities(context.Background(), &csi.NodeGetCapabilitiesRequest{})
+		if err != nil {
+			h.close()
+			t.Fatalf("NodeGetCapabilities failed: %v", err)
+		}
+		for _, c := range resp.GetCapabilities() {
+			h.nodeCaps[c.GetRpc().GetType()] = true
+		}
+	}
+	return h
+}
+
+func (h *harness) close() {
+	if h.conn != nil {
+		h.conn.Close()
+	}
+	h.server.Stop()
+	if h.tempDir {
+		os.RemoveAll(h.targetDir)
+	}
+}
+
+// uniqueName returns a volume name not used by previous scenarios.
+func (h *harness) uniqueName(prefix string) string {
+	return fmt.Sprintf("csitest-%s-%d", prefix, time.Now().UnixNano())
+}
+
+func (h *harness) path(name string) string {
+	return filepath.Join(h.targetDir, name)
+}
+
+func (h *harness) createVolume(t *testing.T, name string) *csi.Volume {
+	resp, err := h.cs.CreateVolume(context.Background(), &csi.CreateVolumeRequest{
+		Name:               name,
+		VolumeCapabilities: []*csi.VolumeCapability{h.config.VolumeCapability},
+		Parameters:         h.config.Parameters,
+	})
+	if err != nil {
+		t.Fatalf("CreateVolume(%s) failed: %v", name, err)
+	}
+	if resp.GetVolume().GetId() == "" {
+		t.Fatalf("CreateVolume(%s) returned no volume ID", name)
+	}
+	return resp.GetVolume()
+}
+
+func (h *harness) deleteVolume(t *testing.T, volumeID string) {
+	_, err := h.cs.DeleteVolume(context.Background(), &csi.DeleteVolumeRequest{VolumeId: volumeID})
+	if err != nil {
+		t.Errorf("DeleteVolume(%s) failed: %v", volumeID, err)
+	}
+}
+
+func needsController(h *harness) string {
+	if h.config.Controller == nil {
+		return "no controller server"
+	}
+	return ""
+}
+
+func needsControllerCap(caps ...csi.ControllerServiceCapability_RPC_Type) func(h *harness) string {
+	return func(h *harness) string {
+		if reason := needsController(h); reason != "" {
+			return reason
+		}
+		for _, c := range caps {
+			if !h.controllerCaps[c] {
+				return fmt.Sprintf("controller capability %v not supported", c)
+			}
+		}
+		return ""
+	}
+}
+
+func needsNoControllerCap(c csi.ControllerServiceCapability_RPC_Type) func(h *harness) string {
+	return func(h *harness) string {
+		if reason := needsController(h); reason != "" {
+			return reason
+		}
+		if h.controllerCaps[c] {
+			return fmt.Sprintf("controller capability %v supported", c)
+		}
+		return ""
+	}
+}
+
+func needsNode(h *harness) string {
+	if h.config.Node == nil {
+		return "no node server"
+	}
+	return ""
+}
+
+func needsNodeCap(c csi.NodeServiceCapability_RPC_Type) func(h *harness) string {
+	return func(h *harness) string {
+		if reason := needsNode(h); reason != "" {
+			return reason
+		}
+		if !h.nodeCaps[c] {
+			return fmt.Sprintf("node capability %v not supported", c)
+		}
+		return ""
+	}
+}
+
+// needsVolume checks that the node scenarios have a volume to work with,
+// either created by the controller or given by the configuration.
+func needsVolume(h *harness) string {
+	if reason := needsNode(h); reason != "" {
+		return reason
+	}
+	if h.config.VolumeID == "" && needsControllerCap(csi.ControllerServiceCapability_RPC_CREATE_DELETE_VOLUME)(h) != "" {
+		return "no volume to publish, set Config.VolumeID"
+	}
+	return ""
+}
+
+func expectCode(t *testing.T, call string, err error, code codes.Code) {
+	if status.Code(err) != code {
+		t.Errorf("%s: expected %v, got %v", call, code, err)
+	}
+}
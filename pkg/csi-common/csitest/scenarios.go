@@ -0,0 +1,359 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package csitest
+
+import (
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi/v0"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+)
+
+func testGetPluginInfo(t *testing.T, h *harness) {
+	resp, err := h.ids.GetPluginInfo(context.Background(), &csi.GetPluginInfoRequest{})
+	if err != nil {
+		t.Fatalf("GetPluginInfo failed: %v", err)
+	}
+	if resp.GetName() == "" {
+		t.Errorf("GetPluginInfo returned no name")
+	}
+	if resp.GetVendorVersion() == "" {
+		t.Errorf("GetPluginInfo returned no vendor version")
+	}
+}
+
+func testProbe(t *testing.T, h *harness) {
+	if _, err := h.ids.Probe(context.Background(), &csi.ProbeRequest{}); err != nil {
+		t.Errorf("Probe failed: %v", err)
+	}
+}
+
+func testGetPluginCapabilities(t *testing.T, h *harness) {
+	resp, err := h.ids.GetPluginCapabilities(context.Background(), &csi.GetPluginCapabilitiesRequest{})
+	if err != nil {
+		t.Fatalf("GetPluginCapabilities failed: %v", err)
+	}
+	controller := false
+	for _, c := range resp.GetCapabilities() {
+		if c.GetService().GetType() == csi.PluginCapability_Service_CONTROLLER_SERVICE {
+			controller = true
+		}
+	}
+	if controller != (h.config.Controller != nil) {
+		t.Errorf("CONTROLLER_SERVICE advertised: %v, controller server present: %v", controller, h.config.Controller != nil)
+	}
+}
+
+func testControllerGetCapabilities(t *testing.T, h *harness) {
+	resp, err := h.cs.ControllerGetCapabilities(context.Background(), &csi.ControllerGetCapabilitiesRequest{})
+	if err != nil {
+		t.Fatalf("ControllerGetCapabilities failed: %v", err)
+	}
+	for _, c := range resp.GetCapabilities() {
+		if c.GetRpc() == nil {
+			t.Errorf("ControllerGetCapabilities returned a capability without RPC type: %v", c)
+		}
+	}
+}
+
+func testCreateVolumeMissingArguments(t *testing.T, h *harness) {
+	_, err := h.cs.CreateVolume(context.Background(), &csi.CreateVolumeRequest{
+		VolumeCapabilities: []*csi.VolumeCapability{h.config.VolumeCapability},
+		Parameters:         h.config.Parameters,
+	})
+	expectCode(t, "CreateVolume without name", err, codes.InvalidArgument)
+
+	_, err = h.cs.CreateVolume(context.Background(), &csi.CreateVolumeRequest{
+		Name:       h.uniqueName("missing-caps"),
+		Parameters: h.config.Parameters,
+	})
+	expectCode(t, "CreateVolume without capabilities", err, codes.InvalidArgument)
+}
+
+func testCreateVolumeIdempotent(t *testing.T, h *harness) {
+	name := h.uniqueName("idempotent")
+	vol := h.createVolume(t, name)
+	defer h.deleteVolume(t, vol.GetId())
+
+	again := h.createVolume(t, name)
+	if again.GetId() != vol.GetId() {
+		t.Errorf("CreateVolume(%s) is not idempotent: got volume %s, then %s", name, vol.GetId(), again.GetId())
+	}
+}
+
+func testCreateVolumeNotSupported(t *testing.T, h *harness) {
+	_, err := h.cs.CreateVolume(context.Background(), &csi.CreateVolumeRequest{
+		Name:               h.uniqueName("unsupported"),
+		VolumeCapabilities: []*csi.VolumeCapability{h.config.VolumeCapability},
+		Parameters:         h.config.Parameters,
+	})
+	if err == nil {
+		t.Errorf("CreateVolume succeeded without CREATE_DELETE_VOLUME capability")
+	}
+}
+
+func testDeleteVolumeMissingArguments(t *testing.T, h *harness) {
+	_, err := h.cs.DeleteVolume(context.Background(), &csi.DeleteVolumeRequest{})
+	expectCode(t, "DeleteVolume without volume ID", err, codes.InvalidArgument)
+}
+
+func testDeleteVolumeIdempotent(t *testing.T, h *harness) {
+	vol := h.createVolume(t, h.uniqueName("delete"))
+
+	h.deleteVolume(t, vol.GetId())
+	// Deleting a volume that is gone already succeeds
+	h.deleteVolume(t, vol.GetId())
+}
+
+func testValidateVolumeCapabilitiesMissingArguments(t *testing.T, h *harness) {
+	_, err := h.cs.ValidateVolumeCapabilities(context.Background(), &csi.ValidateVolumeCapabilitiesRequest{
+		VolumeCapabilities: []*csi.VolumeCapability{h.config.VolumeCapability},
+	})
+	expectCode(t, "ValidateVolumeCapabilities without volume ID", err, codes.InvalidArgument)
+
+	_, err = h.cs.ValidateVolumeCapabilities(context.Background(), &csi.ValidateVolumeCapabilitiesRequest{
+		VolumeId: "csitest-volume",
+	})
+	expectCode(t, "ValidateVolumeCapabilities without capabilities", err, codes.InvalidArgument)
+}
+
+func testValidateVolumeCapabilities(t *testing.T, h *harness) {
+	vol := h.createVolume(t, h.uniqueName("validate"))
+	defer h.deleteVolume(t, vol.GetId())
+
+	resp, err := h.cs.ValidateVolumeCapabilities(context.Background(), &csi.ValidateVolumeCapabilitiesRequest{
+		VolumeId:           vol.GetId(),
+		VolumeCapabilities: []*csi.VolumeCapability{h.config.VolumeCapability},
+		VolumeAttributes:   vol.GetAttributes(),
+	})
+	if err != nil {
+		t.Fatalf("ValidateVolumeCapabilities failed: %v", err)
+	}
+	if !resp.GetSupported() {
+		t.Errorf("the capability the volume was created with is not supported: %s", resp.GetMessage())
+	}
+}
+
+func testControllerPublishMissingArguments(t *testing.T, h *harness) {
+	nodeID := h.nodeID(t)
+
+	_, err := h.cs.ControllerPublishVolume(context.Background(), &csi.ControllerPublishVolumeRequest{
+		NodeId:           nodeID,
+		VolumeCapability: h.config.VolumeCapability,
+	})
+	expectCode(t, "ControllerPublishVolume without volume ID", err, codes.InvalidArgument)
+
+	_, err = h.cs.ControllerPublishVolume(context.Background(), &csi.ControllerPublishVolumeRequest{
+		VolumeId:         "csitest-volume",
+		VolumeCapability: h.config.VolumeCapability,
+	})
+	expectCode(t, "ControllerPublishVolume without node ID", err, codes.InvalidArgument)
+
+	_, err = h.cs.ControllerUnpublishVolume(context.Background(), &csi.ControllerUnpublishVolumeRequest{
+		NodeId: nodeID,
+	})
+	expectCode(t, "ControllerUnpublishVolume without volume ID", err, codes.InvalidArgument)
+}
+
+func testControllerPublishUnpublish(t *testing.T, h *harness) {
+	vol := h.createVolume(t, h.uniqueName("publish"))
+	defer h.deleteVolume(t, vol.GetId())
+	nodeID := h.nodeID(t)
+
+	_, err := h.cs.ControllerPublishVolume(context.Background(), &csi.ControllerPublishVolumeRequest{
+		VolumeId:         vol.GetId(),
+		NodeId:           nodeID,
+		VolumeCapability: h.config.VolumeCapability,
+		VolumeAttributes: vol.GetAttributes(),
+	})
+	if err != nil {
+		t.Fatalf("ControllerPublishVolume failed: %v", err)
+	}
+
+	_, err = h.cs.ControllerUnpublishVolume(context.Background(), &csi.ControllerUnpublishVolumeRequest{
+		VolumeId: vol.GetId(),
+		NodeId:   nodeID,
+	})
+	if err != nil {
+		t.Errorf("ControllerUnpublishVolume failed: %v", err)
+	}
+}
+
+func testNodeGetId(t *testing.T, h *harness) {
+	if h.nodeID(t) == "" {
+		t.Errorf("NodeGetId returned no node ID")
+	}
+}
+
+func testNodeGetCapabilities(t *testing.T, h *harness) {
+	resp, err := h.ns.NodeGetCapabilities(context.Background(), &csi.NodeGetCapabilitiesRequest{})
+	if err != nil {
+		t.Fatalf("NodeGetCapabilities failed: %v", err)
+	}
+	for _, c := range resp.GetCapabilities() {
+		if c.GetRpc() == nil {
+			t.Errorf("NodeGetCapabilities returned a capability without RPC type: %v", c)
+		}
+	}
+}
+
+func testNodeStageMissingArguments(t *testing.T, h *harness) {
+	_, err := h.ns.NodeStageVolume(context.Background(), &csi.NodeStageVolumeRequest{
+		StagingTargetPath: h.path("staging"),
+		VolumeCapability:  h.config.VolumeCapability,
+	})
+	expectCode(t, "NodeStageVolume without volume ID", err, codes.InvalidArgument)
+
+	_, err = h.ns.NodeStageVolume(context.Background(), &csi.NodeStageVolumeRequest{
+		VolumeId:         "csitest-volume",
+		VolumeCapability: h.config.VolumeCapability,
+	})
+	expectCode(t, "NodeStageVolume without staging path", err, codes.InvalidArgument)
+
+	_, err = h.ns.NodeUnstageVolume(context.Background(), &csi.NodeUnstageVolumeRequest{
+		StagingTargetPath: h.path("staging"),
+	})
+	expectCode(t, "NodeUnstageVolume without volume ID", err, codes.InvalidArgument)
+}
+
+func testNodePublishMissingArguments(t *testing.T, h *harness) {
+	_, err := h.ns.NodePublishVolume(context.Background(), &csi.NodePublishVolumeRequest{
+		TargetPath:       h.path("target"),
+		VolumeCapability: h.config.VolumeCapability,
+	})
+	expectCode(t, "NodePublishVolume without volume ID", err, codes.InvalidArgument)
+
+	_, err = h.ns.NodePublishVolume(context.Background(), &csi.NodePublishVolumeRequest{
+		VolumeId:         "csitest-volume",
+		VolumeCapability: h.config.VolumeCapability,
+	})
+	expectCode(t, "NodePublishVolume without target path", err, codes.InvalidArgument)
+
+	_, err = h.ns.NodePublishVolume(context.Background(), &csi.NodePublishVolumeRequest{
+		VolumeId:   "csitest-volume",
+		TargetPath: h.path("target"),
+	})
+	expectCode(t, "NodePublishVolume without capability", err, codes.InvalidArgument)
+}
+
+func testNodeUnpublishMissingArguments(t *testing.T, h *harness) {
+	_, err := h.ns.NodeUnpublishVolume(context.Background(), &csi.NodeUnpublishVolumeRequest{
+		TargetPath: h.path("target"),
+	})
+	expectCode(t, "NodeUnpublishVolume without volume ID", err, codes.InvalidArgument)
+
+	_, err = h.ns.NodeUnpublishVolume(context.Background(), &csi.NodeUnpublishVolumeRequest{
+		VolumeId: "csitest-volume",
+	})
+	expectCode(t, "NodeUnpublishVolume without target path", err, codes.InvalidArgument)
+}
+
+// testNodePublishUnpublish runs the whole life cycle of a volume on the node:
+// create, controller publish, stage, publish and back.
+func testNodePublishUnpublish(t *testing.T, h *harness) {
+	volumeID, attributes := h.config.VolumeID, h.config.VolumeAttributes
+	if volumeID == "" {
+		vol := h.createVolume(t, h.uniqueName("node"))
+		defer h.deleteVolume(t, vol.GetId())
+		volumeID, attributes = vol.GetId(), vol.GetAttributes()
+	}
+	nodeID := h.nodeID(t)
+
+	var publishInfo map[string]string
+	if h.controllerCaps[csi.ControllerServiceCapability_RPC_PUBLISH_UNPUBLISH_VOLUME] {
+		resp, err := h.cs.ControllerPublishVolume(context.Background(), &csi.ControllerPublishVolumeRequest{
+			VolumeId:         volumeID,
+			NodeId:           nodeID,
+			VolumeCapability: h.config.VolumeCapability,
+			VolumeAttributes: attributes,
+		})
+		if err != nil {
+			t.Fatalf("ControllerPublishVolume failed: %v", err)
+		}
+		publishInfo = resp.GetPublishInfo()
+		defer func() {
+			_, err := h.cs.ControllerUnpublishVolume(context.Background(), &csi.ControllerUnpublishVolumeRequest{
+				VolumeId: volumeID,
+				NodeId:   nodeID,
+			})
+			if err != nil {
+				t.Errorf("ControllerUnpublishVolume failed: %v", err)
+			}
+		}()
+	}
+
+	stagingPath := ""
+	if h.nodeCaps[csi.NodeServiceCapability_RPC_STAGE_UNSTAGE_VOLUME] {
+		stagingPath = h.path(volumeID + "-staging")
+		_, err := h.ns.NodeStageVolume(context.Background(), &csi.NodeStageVolumeRequest{
+			VolumeId:          volumeID,
+			PublishInfo:       publishInfo,
+			StagingTargetPath: stagingPath,
+			VolumeCapability:  h.config.VolumeCapability,
+			VolumeAttributes:  attributes,
+		})
+		if err != nil {
+			t.Fatalf("NodeStageVolume failed: %v", err)
+		}
+		defer func() {
+			_, err := h.ns.NodeUnstageVolume(context.Background(), &csi.NodeUnstageVolumeRequest{
+				VolumeId:          volumeID,
+				StagingTargetPath: stagingPath,
+			})
+			if err != nil {
+				t.Errorf("NodeUnstageVolume failed: %v", err)
+			}
+		}()
+	}
+
+	targetPath := h.path(volumeID + "-target")
+	publish := &csi.NodePublishVolumeRequest{
+		VolumeId:          volumeID,
+		PublishInfo:       publishInfo,
+		StagingTargetPath: stagingPath,
+		TargetPath:        targetPath,
+		VolumeCapability:  h.config.VolumeCapability,
+		VolumeAttributes:  attributes,
+	}
+	if _, err := h.ns.NodePublishVolume(context.Background(), publish); err != nil {
+		t.Fatalf("NodePublishVolume failed: %v", err)
+	}
+	// Publishing again to the same target is a no-op
+	if _, err := h.ns.NodePublishVolume(context.Background(), publish); err != nil {
+		t.Errorf("NodePublishVolume is not idempotent: %v", err)
+	}
+
+	_, err := h.ns.NodeUnpublishVolume(context.Background(), &csi.NodeUnpublishVolumeRequest{
+		VolumeId:   volumeID,
+		TargetPath: targetPath,
+	})
+	if err != nil {
+		t.Errorf("NodeUnpublishVolume failed: %v", err)
+	}
+}
+
+func (h *harness) nodeID(t *testing.T) string {
+	if h.config.Node == nil {
+		return "csitest-node"
+	}
+	resp, err := h.ns.NodeGetId(context.Background(), &csi.NodeGetIdRequest{})
+	if err != nil {
+		t.Fatalf("NodeGetId failed: %v", err)
+	}
+	return resp.GetNodeId()
+}
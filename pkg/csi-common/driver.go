@@ -30,7 +30,15 @@ type CSIDriver struct {
 	nodeID  string
 	version string
 	cap     []*csi.ControllerServiceCapability
+	nscap   []*csi.NodeServiceCapability
 	vc      []*csi.VolumeCapability_AccessMode
+	pcap    []*csi.PluginCapability
+	// Whether pcap was set by the driver rather than derived from the
+	// servers being started.
+	pcapSet bool
+	// Whether volumes with the block access type are supported.
+	block  bool
+	health healthChecks
 }
 
 // Creates a NewCSIDriver object. Assumes vendor version is equal to driver version &
@@ -86,6 +94,67 @@ func (d *CSIDriver) AddControllerServiceCapabilities(cl []csi.ControllerServiceC
 	return
 }
 
+func (d *CSIDriver) ValidateNodeServiceRequest(c csi.NodeServiceCapability_RPC_Type) error {
+	if c == csi.NodeServiceCapability_RPC_UNKNOWN {
+		return nil
+	}
+
+	for _, cap := range d.nscap {
+		if c == cap.GetRpc().GetType() {
+			return nil
+		}
+	}
+	return status.Error(codes.InvalidArgument, fmt.Sprintf("%s", c))
+}
+
+func (d *CSIDriver) AddNodeServiceCapabilities(nl []csi.NodeServiceCapability_RPC_Type) {
+	var nsc []*csi.NodeServiceCapability
+
+	for _, n := range nl {
+		glog.Infof("Enabling node service capability: %v", n.String())
+		nsc = append(nsc, NewNodeServiceCapability(n))
+	}
+
+	d.nscap = nsc
+
+	return
+}
+
+// AddPluginCapabilities overrides the plugin capabilities, which otherwise
+// reflect the servers passed to NonBlockingGRPCServer.Start.
+func (d *CSIDriver) AddPluginCapabilities(pl []csi.PluginCapability_Service_Type) {
+	var pc []*csi.PluginCapability
+
+	for _, p := range pl {
+		glog.Infof("Enabling plugin capability: %v", p.String())
+		pc = append(pc, NewPluginCapability(p))
+	}
+
+	d.pcap = pc
+	d.pcapSet = true
+
+	return
+}
+
+func (d *CSIDriver) GetPluginCapabilities() []*csi.PluginCapability {
+	return d.pcap
+}
+
+// setDefaultPluginCapabilities advertises the controller service if the
+// driver serves one, unless the driver set its capabilities itself.
+func (d *CSIDriver) setDefaultPluginCapabilities(controller bool) {
+	if d.pcapSet {
+		return
+	}
+
+	var pc []*csi.PluginCapability
+	if controller {
+		glog.Infof("Enabling plugin capability: %v", csi.PluginCapability_Service_CONTROLLER_SERVICE.String())
+		pc = append(pc, NewPluginCapability(csi.PluginCapability_Service_CONTROLLER_SERVICE))
+	}
+	d.pcap = pc
+}
+
 func (d *CSIDriver) AddVolumeCapabilityAccessModes(vc []csi.VolumeCapability_AccessMode_Mode) []*csi.VolumeCapability_AccessMode {
 	var vca []*csi.VolumeCapability_AccessMode
 	for _, c := range vc {
@@ -99,3 +168,39 @@ func (d *CSIDriver) AddVolumeCapabilityAccessModes(vc []csi.VolumeCapability_Acc
 func (d *CSIDriver) GetVolumeCapabilityAccessModes() []*csi.VolumeCapability_AccessMode {
 	return d.vc
 }
+
+// EnableBlockVolumes allows volumes with the block access type, only the
+// mount access type is supported otherwise.
+func (d *CSIDriver) EnableBlockVolumes() {
+	glog.Infof("Enabling block volumes")
+	d.block = true
+}
+
+// ValidateVolumeCapability checks that the access type and the access mode
+// of c are supported by the driver.
+func (d *CSIDriver) ValidateVolumeCapability(c *csi.VolumeCapability) error {
+	if c.GetBlock() == nil && c.GetMount() == nil {
+		return status.Error(codes.InvalidArgument, "Volume access type missing")
+	}
+	if c.GetBlock() != nil && !d.block {
+		return status.Error(codes.InvalidArgument, "Driver does not support block volumes")
+	}
+	for _, vc := range d.vc {
+		if vc.GetMode() == c.GetAccessMode().GetMode() {
+			return nil
+		}
+	}
+	return status.Error(codes.InvalidArgument, "Driver does not support mode: "+c.GetAccessMode().GetMode().String())
+}
+
+// AddHealthCheck registers a check run by the default Probe. The name
+// identifies the check in the error reported when it fails.
+func (d *CSIDriver) AddHealthCheck(name string, check HealthCheck) {
+	glog.Infof("Enabling health check: %v", name)
+	d.health.add(name, check)
+}
+
+// CheckHealth runs the registered health checks.
+func (d *CSIDriver) CheckHealth() error {
+	return d.health.run()
+}
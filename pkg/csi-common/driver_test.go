@@ -102,3 +102,63 @@ func TestValidateControllerServiceRequest(t *testing.T) {
 	assert.NoError(t, err)
 
 }
+
+func TestValidateNodeServiceRequest(t *testing.T) {
+	d := NewFakeDriver()
+
+	// Valid requests which require no capabilities
+	err := d.ValidateNodeServiceRequest(csi.NodeServiceCapability_RPC_UNKNOWN)
+	assert.NoError(t, err)
+
+	// Test node service stage/unstage not supported
+	err = d.ValidateNodeServiceRequest(csi.NodeServiceCapability_RPC_STAGE_UNSTAGE_VOLUME)
+	s, ok := status.FromError(err)
+	assert.True(t, ok)
+	assert.Equal(t, s.Code(), codes.InvalidArgument)
+
+	// Add node service stage & unstage request
+	d.AddNodeServiceCapabilities(
+		[]csi.NodeServiceCapability_RPC_Type{
+			csi.NodeServiceCapability_RPC_STAGE_UNSTAGE_VOLUME,
+		})
+
+	// Test node service stage/unstage is supported
+	err = d.ValidateNodeServiceRequest(csi.NodeServiceCapability_RPC_STAGE_UNSTAGE_VOLUME)
+	assert.NoError(t, err)
+}
+
+func TestValidateVolumeCapability(t *testing.T) {
+	d := NewFakeDriver()
+	d.AddVolumeCapabilityAccessModes([]csi.VolumeCapability_AccessMode_Mode{csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER})
+
+	mode := &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER}
+	mountCap := &csi.VolumeCapability{
+		AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{}},
+		AccessMode: mode,
+	}
+	blockCap := &csi.VolumeCapability{
+		AccessType: &csi.VolumeCapability_Block{Block: &csi.VolumeCapability_BlockVolume{}},
+		AccessMode: mode,
+	}
+
+	// Test mount volumes are supported
+	assert.NoError(t, d.ValidateVolumeCapability(mountCap))
+
+	// Test block volumes are not supported by default
+	err := d.ValidateVolumeCapability(blockCap)
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	d.EnableBlockVolumes()
+	assert.NoError(t, d.ValidateVolumeCapability(blockCap))
+
+	// Test access type missing
+	err = d.ValidateVolumeCapability(&csi.VolumeCapability{AccessMode: mode})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	// Test access mode not supported
+	err = d.ValidateVolumeCapability(&csi.VolumeCapability{
+		AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{}},
+		AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER},
+	})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
@@ -0,0 +1,86 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package csicommon
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+)
+
+// HealthCheck verifies that something the driver depends on is usable. It
+// returns an error describing the problem otherwise.
+type HealthCheck func() error
+
+type namedHealthCheck struct {
+	name  string
+	check HealthCheck
+}
+
+// healthChecks is the registry of the checks run by Probe.
+type healthChecks struct {
+	mux    sync.RWMutex
+	checks []namedHealthCheck
+}
+
+func (hc *healthChecks) add(name string, check HealthCheck) {
+	hc.mux.Lock()
+	defer hc.mux.Unlock()
+	hc.checks = append(hc.checks, namedHealthCheck{name: name, check: check})
+}
+
+// run executes all the checks and aggregates the failures in one error.
+func (hc *healthChecks) run() error {
+	hc.mux.RLock()
+	defer hc.mux.RUnlock()
+
+	var failures []string
+	for _, c := range hc.checks {
+		if err := c.check(); err != nil {
+			failures = append(failures, fmt.Sprintf("%s: %v", c.name, err))
+		}
+	}
+	if len(failures) > 0 {
+		return fmt.Errorf("health check failed: %s", strings.Join(failures, "; "))
+	}
+	return nil
+}
+
+// BinaryHealthCheck checks that the executable can be found in PATH.
+func BinaryHealthCheck(binary string) HealthCheck {
+	return func() error {
+		if _, err := exec.LookPath(binary); err != nil {
+			return fmt.Errorf("%s not found: %v", binary, err)
+		}
+		return nil
+	}
+}
+
+// WritableDirHealthCheck checks that files can be created in dir.
+func WritableDirHealthCheck(dir string) HealthCheck {
+	return func() error {
+		f, err := ioutil.TempFile(dir, ".probe")
+		if err != nil {
+			return fmt.Errorf("%s is not writable: %v", dir, err)
+		}
+		f.Close()
+		return os.Remove(f.Name())
+	}
+}
@@ -0,0 +1,45 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package csicommon
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBinaryHealthCheck(t *testing.T) {
+	assert.NoError(t, BinaryHealthCheck("sh")())
+	assert.Error(t, BinaryHealthCheck("no-such-binary-for-csi")())
+}
+
+func TestWritableDirHealthCheck(t *testing.T) {
+	dir, err := ioutil.TempDir("", "csi-health")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	assert.NoError(t, WritableDirHealthCheck(dir)())
+	// The probe file is cleaned up
+	files, err := ioutil.ReadDir(dir)
+	assert.NoError(t, err)
+	assert.Empty(t, files)
+
+	assert.Error(t, WritableDirHealthCheck(filepath.Join(dir, "missing"))())
+}
@@ -46,20 +46,26 @@ func (ids *DefaultIdentityServer) GetPluginInfo(ctx context.Context, req *csi.Ge
 }
 
 func (ids *DefaultIdentityServer) Probe(ctx context.Context, req *csi.ProbeRequest) (*csi.ProbeResponse, error) {
+	if err := ids.Driver.CheckHealth(); err != nil {
+		glog.Warningf("Probe failed: %v", err)
+		return nil, status.Error(codes.FailedPrecondition, err.Error())
+	}
 	return &csi.ProbeResponse{}, nil
 }
 
 func (ids *DefaultIdentityServer) GetPluginCapabilities(ctx context.Context, req *csi.GetPluginCapabilitiesRequest) (*csi.GetPluginCapabilitiesResponse, error) {
 	glog.V(5).Infof("Using default capabilities")
 	return &csi.GetPluginCapabilitiesResponse{
-		Capabilities: []*csi.PluginCapability{
-			{
-				Type: &csi.PluginCapability_Service_{
-					Service: &csi.PluginCapability_Service{
-						Type: csi.PluginCapability_Service_CONTROLLER_SERVICE,
-					},
-				},
-			},
-		},
+		Capabilities: ids.Driver.GetPluginCapabilities(),
 	}, nil
 }
+
+// driver gives NonBlockingGRPCServer access to the CSIDriver of identity
+// servers built on DefaultIdentityServer.
+func (ids *DefaultIdentityServer) driver() *CSIDriver {
+	return ids.Driver
+}
+
+type driverIdentityServer interface {
+	driver() *CSIDriver
+}
@@ -18,10 +18,13 @@ package csicommon
 
 import (
 	"context"
+	"errors"
 	"testing"
 
 	"github.com/container-storage-interface/spec/lib/go/csi/v0"
 	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 func TestGetPluginInfo(t *testing.T) {
@@ -35,3 +38,52 @@ func TestGetPluginInfo(t *testing.T) {
 	assert.Equal(t, resp.GetName(), fakeDriverName)
 	assert.Equal(t, resp.GetVendorVersion(), vendorVersion)
 }
+
+func TestProbe(t *testing.T) {
+	d := NewFakeDriver()
+
+	ids := NewDefaultIdentityServer(d)
+
+	// No checks registered
+	_, err := ids.Probe(context.Background(), &csi.ProbeRequest{})
+	assert.NoError(t, err)
+
+	d.AddHealthCheck("ok", func() error { return nil })
+	_, err = ids.Probe(context.Background(), &csi.ProbeRequest{})
+	assert.NoError(t, err)
+
+	// Any failing check fails the probe
+	d.AddHealthCheck("broken", func() error { return errors.New("not configured") })
+	_, err = ids.Probe(context.Background(), &csi.ProbeRequest{})
+	s, ok := status.FromError(err)
+	assert.True(t, ok)
+	assert.Equal(t, codes.FailedPrecondition, s.Code())
+	assert.Contains(t, s.Message(), "broken: not configured")
+	assert.NotContains(t, s.Message(), "ok:")
+}
+
+func TestGetPluginCapabilities(t *testing.T) {
+	d := NewFakeDriver()
+
+	ids := NewDefaultIdentityServer(d)
+
+	// Node only driver
+	d.setDefaultPluginCapabilities(false)
+	resp, err := ids.GetPluginCapabilities(context.Background(), &csi.GetPluginCapabilitiesRequest{})
+	assert.NoError(t, err)
+	assert.Empty(t, resp.GetCapabilities())
+
+	// Driver serving a controller
+	d.setDefaultPluginCapabilities(true)
+	resp, err = ids.GetPluginCapabilities(context.Background(), &csi.GetPluginCapabilitiesRequest{})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(resp.GetCapabilities()))
+	assert.Equal(t, csi.PluginCapability_Service_CONTROLLER_SERVICE, resp.GetCapabilities()[0].GetService().GetType())
+
+	// Capabilities set by the driver are kept
+	d.AddPluginCapabilities([]csi.PluginCapability_Service_Type{})
+	d.setDefaultPluginCapabilities(true)
+	resp, err = ids.GetPluginCapabilities(context.Background(), &csi.GetPluginCapabilitiesRequest{})
+	assert.NoError(t, err)
+	assert.Empty(t, resp.GetCapabilities())
+}
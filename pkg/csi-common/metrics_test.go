@@ -0,0 +1,85 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package csicommon
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func counterValue(t *testing.T, labels ...string) float64 {
+	m := &dto.Metric{}
+	assert.NoError(t, grpcRequests.WithLabelValues(labels...).Write(m))
+	return m.GetCounter().GetValue()
+}
+
+func histogramCount(t *testing.T, method string) uint64 {
+	m := &dto.Metric{}
+	assert.NoError(t, grpcRequestDuration.WithLabelValues(method).(prometheus.Metric).Write(m))
+	return m.GetHistogram().GetSampleCount()
+}
+
+func TestMetricsGRPC(t *testing.T) {
+	info := &grpc.UnaryServerInfo{FullMethod: "/csi.v0.Node/NodeTestMetrics"}
+	ok := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return "resp", nil
+	}
+	fail := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return nil, status.Error(codes.NotFound, "not found")
+	}
+
+	okCount := counterValue(t, info.FullMethod, "OK")
+	notFoundCount := counterValue(t, info.FullMethod, "NotFound")
+	observed := histogramCount(t, info.FullMethod)
+
+	resp, err := metricsGRPC(context.Background(), "req", info, ok)
+	assert.NoError(t, err)
+	assert.Equal(t, "resp", resp)
+	_, err = metricsGRPC(context.Background(), "req", info, fail)
+	assert.Error(t, err)
+
+	assert.Equal(t, okCount+1, counterValue(t, info.FullMethod, "OK"))
+	assert.Equal(t, notFoundCount+1, counterValue(t, info.FullMethod, "NotFound"))
+	assert.Equal(t, observed+2, histogramCount(t, info.FullMethod))
+}
+
+func TestChainUnaryServer(t *testing.T) {
+	var calls []string
+	interceptor := func(name string) grpc.UnaryServerInterceptor {
+		return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+			calls = append(calls, name)
+			return handler(ctx, req)
+		}
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		calls = append(calls, "handler")
+		return req, nil
+	}
+
+	chained := chainUnaryServer(interceptor("first"), interceptor("second"))
+	resp, err := chained(context.Background(), "req", &grpc.UnaryServerInfo{}, handler)
+	assert.NoError(t, err)
+	assert.Equal(t, "req", resp)
+	assert.Equal(t, []string{"first", "second", "handler"}, calls)
+}
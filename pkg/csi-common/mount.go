@@ -0,0 +1,304 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package csicommon
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/golang/glog"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/kubernetes/pkg/util/mount"
+	"k8s.io/kubernetes/pkg/volume/util"
+)
+
+// Mounter mounts volumes on the staging and target paths of the node
+// servers. All its methods are idempotent and return gRPC status errors.
+type Mounter struct {
+	*mount.SafeFormatAndMount
+}
+
+func NewMounter() *Mounter {
+	return &Mounter{
+		SafeFormatAndMount: &mount.SafeFormatAndMount{
+			Interface: mount.New(""),
+			Exec:      mount.NewOsExec(),
+		},
+	}
+}
+
+// MountOptions returns the options to mount a volume with: the given extra
+// options, e.g. "bind", then "ro" or "rw" and the mount flags of the volume
+// capability. readOnly overrides any "ro" or "rw" flag, duplicates are
+// dropped.
+func MountOptions(readOnly bool, flags []string, extra ...string) []string {
+	options := []string{}
+	seen := map[string]bool{}
+	add := func(option string) {
+		if option != "" && !seen[option] {
+			seen[option] = true
+			options = append(options, option)
+		}
+	}
+
+	for _, option := range extra {
+		add(option)
+	}
+	if readOnly {
+		add("ro")
+	} else {
+		add("rw")
+	}
+	for _, option := range flags {
+		if option == "ro" || option == "rw" {
+			continue
+		}
+		add(option)
+	}
+	return options
+}
+
+// PrepareTarget creates the target directory if needed and reports whether
+// something is mounted on it already.
+func (m *Mounter) PrepareTarget(target string, bind bool) (bool, error) {
+	notMnt, err := m.isNotMountPoint(target, bind)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			return false, status.Error(codes.Internal, err.Error())
+		}
+		if err := os.MkdirAll(target, 0750); err != nil {
+			return false, status.Error(codes.Internal, err.Error())
+		}
+		notMnt = true
+	}
+	return !notMnt, nil
+}
+
+// Mount mounts source on target. If target is mounted already, the existing
+// mount must be compatible with the request.
+func (m *Mounter) Mount(source, target, fsType string, options []string) error {
+	return m.mount(source, target, fsType, options, false)
+}
+
+// FormatAndMount is Mount formatting the source device first if it does not
+// contain a file system yet.
+func (m *Mounter) FormatAndMount(source, target, fsType string, options []string) error {
+	return m.mount(source, target, fsType, options, true)
+}
+
+// MountBlock publishes the device node source as a raw block volume by bind
+// mounting it on the file target, which is created if needed.
+func (m *Mounter) MountBlock(source, target string, readOnly bool) error {
+	mounted, err := m.prepareBlockTarget(target)
+	if err != nil {
+		return err
+	}
+	return m.doMount(mounted, source, target, "", MountOptions(readOnly, nil, "bind"), false)
+}
+
+func (m *Mounter) mount(source, target, fsType string, options []string, format bool) error {
+	mounted, err := m.PrepareTarget(target, hasOption(options, "bind"))
+	if err != nil {
+		return err
+	}
+	return m.doMount(mounted, source, target, fsType, options, format)
+}
+
+func (m *Mounter) doMount(mounted bool, source, target, fsType string, options []string, format bool) error {
+	bind := hasOption(options, "bind")
+	if mounted {
+		return m.checkMount(source, target, options, bind)
+	}
+
+	glog.V(4).Infof("Mounting %s on %s, fstype %q, options %v", source, target, fsType, options)
+	var err error
+	if format {
+		err = m.SafeFormatAndMount.FormatAndMount(source, target, fsType, options)
+	} else {
+		err = m.Interface.Mount(source, target, fsType, options)
+	}
+	if err != nil {
+		return mountError(err)
+	}
+	return nil
+}
+
+// Unmount unmounts target and removes it, target may be the directory of a
+// mounted volume or the file of a block volume. A target which does not
+// exist or is not mounted is not an error.
+func (m *Mounter) Unmount(target string) error {
+	if err := util.UnmountMountPoint(target, m.Interface, true /* extensiveMountPointCheck */); err != nil {
+		return status.Error(codes.Internal, err.Error())
+	}
+	return nil
+}
+
+// prepareBlockTarget creates the file target and its parent directory if
+// needed and reports whether something is mounted on it already.
+func (m *Mounter) prepareBlockTarget(target string) (bool, error) {
+	notMnt, err := mount.IsNotMountPoint(m.Interface, target)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			return false, status.Error(codes.Internal, err.Error())
+		}
+		if err := os.MkdirAll(filepath.Dir(target), 0750); err != nil {
+			return false, status.Error(codes.Internal, err.Error())
+		}
+		f, err := os.OpenFile(target, os.O_CREATE, 0640)
+		if err != nil {
+			return false, status.Error(codes.Internal, err.Error())
+		}
+		f.Close()
+		notMnt = true
+	}
+	return !notMnt, nil
+}
+
+// IsBlockTarget reports whether target is the file a block volume is
+// published on rather than a directory. A target which does not exist is
+// not a block target.
+func IsBlockTarget(target string) (bool, error) {
+	fi, err := os.Stat(target)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	return !fi.IsDir(), nil
+}
+
+func (m *Mounter) isNotMountPoint(target string, bind bool) (bool, error) {
+	// Bind mounts are not detected by the heuristic, the mount table has to
+	// be checked.
+	if bind {
+		return mount.IsNotMountPoint(m.Interface, target)
+	}
+	return m.IsLikelyNotMountPoint(target)
+}
+
+// checkMount verifies that the existing mount on target is what the request
+// asks for, the CSI spec requires AlreadyExists otherwise.
+func (m *Mounter) checkMount(source, target string, options []string, bind bool) error {
+	mp, err := m.findMountPoint(target)
+	if err != nil {
+		return status.Error(codes.Internal, err.Error())
+	}
+	if mp == nil {
+		// Mounted according to the heuristic but not found in the mount
+		// table, nothing to compare against.
+		glog.V(4).Infof("%s is mounted already", target)
+		return nil
+	}
+
+	// The mount table shows the device of a bind mount rather than its
+	// source directory, the source can only be compared otherwise.
+	if !bind && !sameDevice(mp.Device, source) {
+		return status.Errorf(codes.AlreadyExists, "%s is mounted from %s, not %s", target, mp.Device, source)
+	}
+	if err := checkReadOnly(mp, options); err != nil {
+		return err
+	}
+	glog.V(4).Infof("%s is mounted already", target)
+	return nil
+}
+
+// CheckMount verifies that the existing mount on target, whose source is
+// not known to the caller, has the file system type and the read-only flag
+// requested.
+func (m *Mounter) CheckMount(target, fsType string, options []string) error {
+	mp, err := m.findMountPoint(target)
+	if err != nil {
+		return status.Error(codes.Internal, err.Error())
+	}
+	if mp == nil {
+		glog.V(4).Infof("%s is mounted already", target)
+		return nil
+	}
+
+	if fsType != "" && mp.Type != "" && mp.Type != fsType {
+		return status.Errorf(codes.AlreadyExists, "%s is mounted with file system %s, not %s", mp.Path, mp.Type, fsType)
+	}
+	if err := checkReadOnly(mp, options); err != nil {
+		return err
+	}
+	glog.V(4).Infof("%s is mounted already", target)
+	return nil
+}
+
+func checkReadOnly(mp *mount.MountPoint, options []string) error {
+	if len(mp.Opts) > 0 && hasOption(mp.Opts, "ro") != hasOption(options, "ro") {
+		return status.Errorf(codes.AlreadyExists, "%s is mounted with incompatible options %v", mp.Path, mp.Opts)
+	}
+	return nil
+}
+
+// sameDevice reports whether the device of a mount is source, following
+// the symbolic links such as /dev/disk/by-path or /dev/mapper ones.
+func sameDevice(device, source string) bool {
+	if device == source {
+		return true
+	}
+	resolved, err := filepath.EvalSymlinks(source)
+	if err != nil {
+		return false
+	}
+	if device == resolved {
+		return true
+	}
+	resolvedDevice, err := filepath.EvalSymlinks(device)
+	return err == nil && resolvedDevice == resolved
+}
+
+func (m *Mounter) findMountPoint(target string) (*mount.MountPoint, error) {
+	path, err := filepath.EvalSymlinks(target)
+	if err != nil {
+		path = target
+	}
+	mps, err := m.List()
+	if err != nil {
+		return nil, err
+	}
+	for i := range mps {
+		if m.IsMountPointMatch(mps[i], path) {
+			return &mps[i], nil
+		}
+	}
+	return nil, nil
+}
+
+// mountError maps a mount failure to a gRPC status.
+func mountError(err error) error {
+	if os.IsPermission(err) {
+		return status.Error(codes.PermissionDenied, err.Error())
+	}
+	if strings.Contains(err.Error(), "invalid argument") {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+	return status.Error(codes.Internal, err.Error())
+}
+
+func hasOption(options []string, option string) bool {
+	for _, o := range options {
+		if o == option {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,183 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package csicommon
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/kubernetes/pkg/util/mount"
+)
+
+func newFakeMounter() (*Mounter, *mount.FakeMounter) {
+	fake := &mount.FakeMounter{}
+	return &Mounter{SafeFormatAndMount: &mount.SafeFormatAndMount{Interface: fake}}, fake
+}
+
+func TestMountOptions(t *testing.T) {
+	assert.Equal(t, []string{"rw"}, MountOptions(false, nil))
+	assert.Equal(t, []string{"ro"}, MountOptions(true, nil))
+	assert.Equal(t, []string{"bind", "ro", "noatime"}, MountOptions(true, []string{"noatime"}, "bind"))
+	// readOnly wins over the flags, duplicates are dropped
+	assert.Equal(t, []string{"ro", "noatime"}, MountOptions(true, []string{"rw", "noatime", "noatime"}))
+	assert.Equal(t, []string{"rw", "vers=4"}, MountOptions(false, []string{"ro", "vers=4"}))
+}
+
+func TestMounterMount(t *testing.T) {
+	dir, err := ioutil.TempDir("", "csi-mount")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	target := filepath.Join(dir, "target")
+
+	m, fake := newFakeMounter()
+
+	// The target is created
+	assert.NoError(t, m.Mount("server:/share", target, "nfs", []string{"rw"}))
+	_, err = os.Stat(target)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(fake.Log))
+
+	// Mounting the same source again is a no-op
+	assert.NoError(t, m.Mount("server:/share", target, "nfs", []string{"rw"}))
+	assert.Equal(t, 1, len(fake.Log))
+
+	// Another source cannot be mounted on the target
+	err = m.Mount("server:/other", target, "nfs", []string{"rw"})
+	assert.Equal(t, codes.AlreadyExists, status.Code(err))
+}
+
+func TestMounterCheckOptions(t *testing.T) {
+	dir, err := ioutil.TempDir("", "csi-mount")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	m, fake := newFakeMounter()
+	fake.MountPoints = []mount.MountPoint{{Device: "/dev/sdb", Path: dir, Opts: []string{"ro", "relatime"}}}
+
+	assert.NoError(t, m.Mount("/dev/sdb", dir, "ext4", []string{"ro"}))
+	err = m.Mount("/dev/sdb", dir, "ext4", []string{"rw"})
+	assert.Equal(t, codes.AlreadyExists, status.Code(err))
+}
+
+func TestMounterCheckDevice(t *testing.T) {
+	dir, err := ioutil.TempDir("", "csi-mount")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	target := filepath.Join(dir, "target")
+	assert.NoError(t, os.Mkdir(target, 0750))
+
+	// by-path link to the mounted device
+	device := filepath.Join(dir, "sdb")
+	link := filepath.Join(dir, "ip-10.0.0.1:3260-iscsi-iqn-lun-0")
+	assert.NoError(t, ioutil.WriteFile(device, nil, 0644))
+	assert.NoError(t, os.Symlink(device, link))
+
+	m, fake := newFakeMounter()
+	fake.MountPoints = []mount.MountPoint{{Device: device, Path: target, Type: "ext4"}}
+	assert.NoError(t, m.FormatAndMount(link, target, "ext4", []string{"rw"}))
+	err = m.FormatAndMount(filepath.Join(dir, "sdc"), target, "ext4", []string{"rw"})
+	assert.Equal(t, codes.AlreadyExists, status.Code(err))
+	assert.Empty(t, fake.Log)
+}
+
+func TestMounterCheckMount(t *testing.T) {
+	dir, err := ioutil.TempDir("", "csi-mount")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	m, fake := newFakeMounter()
+	fake.MountPoints = []mount.MountPoint{{Device: "/dev/sdb", Path: dir, Type: "ext4", Opts: []string{"rw"}}}
+
+	assert.NoError(t, m.CheckMount(dir, "ext4", []string{"rw"}))
+	assert.NoError(t, m.CheckMount(dir, "", []string{"rw"}))
+	assert.Equal(t, codes.AlreadyExists, status.Code(m.CheckMount(dir, "xfs", []string{"rw"})))
+	assert.Equal(t, codes.AlreadyExists, status.Code(m.CheckMount(dir, "ext4", []string{"ro"})))
+}
+
+func TestMounterBindMount(t *testing.T) {
+	dir, err := ioutil.TempDir("", "csi-mount")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	target := filepath.Join(dir, "target")
+
+	m, fake := newFakeMounter()
+
+	options := MountOptions(false, nil, "bind")
+	assert.NoError(t, m.Mount(filepath.Join(dir, "source"), target, "", options))
+	// The mount table shows the device rather than the source directory of
+	// bind mounts, publishing again still succeeds
+	fake.MountPoints[0].Device = "/dev/sda1"
+	assert.NoError(t, m.Mount(filepath.Join(dir, "source"), target, "", options))
+	assert.Equal(t, 1, len(fake.Log))
+}
+
+func TestMounterUnmount(t *testing.T) {
+	dir, err := ioutil.TempDir("", "csi-mount")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	target := filepath.Join(dir, "target")
+
+	m, fake := newFakeMounter()
+	assert.NoError(t, m.Mount("server:/share", target, "nfs", nil))
+
+	// The target is unmounted and removed
+	assert.NoError(t, m.Unmount(target))
+	assert.Empty(t, fake.MountPoints)
+	_, err = os.Stat(target)
+	assert.True(t, os.IsNotExist(err))
+
+	// Unmounting again succeeds
+	assert.NoError(t, m.Unmount(target))
+}
+
+func TestMounterMountBlock(t *testing.T) {
+	dir, err := ioutil.TempDir("", "csi-mount")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	target := filepath.Join(dir, "pod", "block")
+
+	m, fake := newFakeMounter()
+
+	// The target is a file created along with its parent directory
+	assert.NoError(t, m.MountBlock("/dev/sdb", target, false))
+	isBlock, err := IsBlockTarget(target)
+	assert.NoError(t, err)
+	assert.True(t, isBlock)
+	assert.Equal(t, 1, len(fake.Log))
+	assert.Equal(t, "/dev/sdb", fake.Log[0].Source)
+
+	// Publishing again is a no-op
+	assert.NoError(t, m.MountBlock("/dev/sdb", target, false))
+	assert.Equal(t, 1, len(fake.Log))
+
+	// The file is removed on unmount
+	assert.NoError(t, m.Unmount(target))
+	_, err = os.Stat(target)
+	assert.True(t, os.IsNotExist(err))
+
+	isBlock, err = IsBlockTarget(target)
+	assert.NoError(t, err)
+	assert.False(t, isBlock)
+	isBlock, err = IsBlockTarget(dir)
+	assert.NoError(t, err)
+	assert.False(t, isBlock)
+}
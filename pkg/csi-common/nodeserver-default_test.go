@@ -45,8 +45,16 @@ func TestNodeGetCapabilities(t *testing.T) {
 
 	// Test valid request
 	req := csi.NodeGetCapabilitiesRequest{}
-	_, err := ns.NodeGetCapabilities(context.Background(), &req)
+	resp, err := ns.NodeGetCapabilities(context.Background(), &req)
 	assert.NoError(t, err)
+	assert.Empty(t, resp.GetCapabilities())
+
+	// Test driver with node capabilities
+	d.AddNodeServiceCapabilities([]csi.NodeServiceCapability_RPC_Type{csi.NodeServiceCapability_RPC_STAGE_UNSTAGE_VOLUME})
+	resp, err = ns.NodeGetCapabilities(context.Background(), &req)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(resp.GetCapabilities()))
+	assert.Equal(t, csi.NodeServiceCapability_RPC_STAGE_UNSTAGE_VOLUME, resp.GetCapabilities()[0].GetRpc().GetType())
 }
 
 func TestNodePublishVolume(t *testing.T) {
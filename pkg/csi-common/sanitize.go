@@ -0,0 +1,139 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package csicommon
+
+import (
+	"reflect"
+	"strings"
+	"sync"
+
+	"github.com/golang/protobuf/proto"
+)
+
+const strippedValue = "***stripped***"
+
+var sensitiveKeys = struct {
+	sync.RWMutex
+	keys map[string]struct{}
+}{keys: map[string]struct{}{}}
+
+// AddSensitiveAttributeKeys marks volume attribute and parameter keys whose
+// values must not be logged. A key also covers the keys below it, e.g.
+// "kubernetes.io/secret" covers "kubernetes.io/secret/password".
+func AddSensitiveAttributeKeys(keys ...string) {
+	sensitiveKeys.Lock()
+	defer sensitiveKeys.Unlock()
+	for _, key := range keys {
+		if key = strings.TrimSpace(key); key != "" {
+			sensitiveKeys.keys[key] = struct{}{}
+		}
+	}
+}
+
+func isSensitiveKey(key string) bool {
+	sensitiveKeys.RLock()
+	defer sensitiveKeys.RUnlock()
+	for k := range sensitiveKeys.keys {
+		if key == k || strings.HasPrefix(key, k+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// sensitiveKeysFlag is a flag.Value adding comma separated keys to the
+// sensitive attribute keys.
+type sensitiveKeysFlag struct{}
+
+func (sensitiveKeysFlag) String() string {
+	return ""
+}
+
+func (sensitiveKeysFlag) Set(value string) error {
+	AddSensitiveAttributeKeys(strings.Split(value, ",")...)
+	return nil
+}
+
+// StripSensitiveAttributes returns a copy of attrs with the values of the
+// sensitive keys masked.
+func StripSensitiveAttributes(attrs map[string]string) map[string]string {
+	if attrs == nil {
+		return nil
+	}
+	stripped := make(map[string]string, len(attrs))
+	for k, v := range attrs {
+		if isSensitiveKey(k) {
+			v = strippedValue
+		}
+		stripped[k] = v
+	}
+	return stripped
+}
+
+// StripSecrets returns a copy of a CSI message suitable for logging: the
+// values of all the secret maps and of the sensitive attribute keys are
+// masked. Anything else than a protobuf message is returned as is.
+func StripSecrets(msg interface{}) interface{} {
+	m, ok := msg.(proto.Message)
+	if !ok || reflect.ValueOf(m).IsNil() {
+		return msg
+	}
+	stripped := proto.Clone(m)
+	stripValue(reflect.ValueOf(stripped))
+	return stripped
+}
+
+func stripValue(v reflect.Value) {
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if !v.IsNil() {
+			stripValue(v.Elem())
+		}
+	case reflect.Slice:
+		for i := 0; i < v.Len(); i++ {
+			stripValue(v.Index(i))
+		}
+	case reflect.Struct:
+		t := v.Type()
+		for i := 0; i < v.NumField(); i++ {
+			field := v.Field(i)
+			if !field.CanSet() {
+				continue
+			}
+			if attrs, ok := field.Interface().(map[string]string); ok {
+				if strings.HasSuffix(t.Field(i).Name, "Secrets") {
+					field.Set(reflect.ValueOf(stripAll(attrs)))
+				} else {
+					field.Set(reflect.ValueOf(StripSensitiveAttributes(attrs)))
+				}
+				continue
+			}
+			stripValue(field)
+		}
+	}
+}
+
+func stripAll(secrets map[string]string) map[string]string {
+	if secrets == nil {
+		return nil
+	}
+	stripped := make(map[string]string, len(secrets))
+	for k := range secrets {
+		stripped[k] = strippedValue
+	}
+	return stripped
+}
@@ -0,0 +1,83 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package csicommon
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi/v0"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStripSecrets(t *testing.T) {
+	AddSensitiveAttributeKeys("chap")
+
+	req := &csi.NodePublishVolumeRequest{
+		VolumeId:           "vol1",
+		TargetPath:         "/mnt/target",
+		NodePublishSecrets: map[string]string{"password": "hunter2"},
+		VolumeAttributes:   map[string]string{"portal": "10.0.0.1:3260", "chap": "hunter3", "chap/user": "admin"},
+	}
+
+	stripped := StripSecrets(req).(*csi.NodePublishVolumeRequest)
+	assert.Equal(t, map[string]string{"password": strippedValue}, stripped.NodePublishSecrets)
+	assert.Equal(t, "10.0.0.1:3260", stripped.VolumeAttributes["portal"])
+	assert.Equal(t, strippedValue, stripped.VolumeAttributes["chap"])
+	assert.Equal(t, strippedValue, stripped.VolumeAttributes["chap/user"])
+	assert.Equal(t, "vol1", stripped.VolumeId)
+
+	// Nothing sensitive is left in the logged text
+	text := fmt.Sprintf("%+v", stripped)
+	assert.NotContains(t, text, "hunter")
+	assert.NotContains(t, text, "admin")
+
+	// The original request is untouched
+	assert.Equal(t, "hunter2", req.NodePublishSecrets["password"])
+	assert.Equal(t, "hunter3", req.VolumeAttributes["chap"])
+}
+
+func TestStripSecretsNested(t *testing.T) {
+	AddSensitiveAttributeKeys("chap")
+
+	resp := &csi.CreateVolumeResponse{
+		Volume: &csi.Volume{
+			Id:         "vol1",
+			Attributes: map[string]string{"chap": "hunter2"},
+		},
+	}
+	stripped := StripSecrets(resp).(*csi.CreateVolumeResponse)
+	assert.Equal(t, strippedValue, stripped.Volume.Attributes["chap"])
+
+	req := &csi.ValidateVolumeCapabilitiesRequest{
+		VolumeId: "vol1",
+		VolumeCapabilities: []*csi.VolumeCapability{
+			{AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{FsType: "ext4"}}},
+		},
+		VolumeAttributes: map[string]string{"chap": "hunter2"},
+	}
+	strippedReq := StripSecrets(req).(*csi.ValidateVolumeCapabilitiesRequest)
+	assert.Equal(t, strippedValue, strippedReq.VolumeAttributes["chap"])
+	assert.Equal(t, "ext4", strippedReq.VolumeCapabilities[0].GetMount().GetFsType())
+}
+
+func TestStripSecretsNotProto(t *testing.T) {
+	assert.Equal(t, "text", StripSecrets("text"))
+	assert.Nil(t, StripSecrets(nil))
+	var req *csi.ProbeRequest
+	assert.Equal(t, req, StripSecrets(req))
+}
@@ -17,49 +17,120 @@ limitations under the License.
 package csicommon
 
 import (
+	"flag"
+	"fmt"
 	"net"
+	"net/http"
 	"os"
+	"os/signal"
+	"strings"
 	"sync"
+	"syscall"
+	"time"
 
 	"github.com/golang/glog"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
 
 	"github.com/container-storage-interface/spec/lib/go/csi/v0"
 )
 
+const (
+	defaultShutdownGracePeriod = 30 * time.Second
+)
+
 // Defines Non blocking GRPC server interfaces
 type NonBlockingGRPCServer interface {
 	// Start services at the endpoint
 	Start(endpoint string, ids csi.IdentityServer, cs csi.ControllerServer, ns csi.NodeServer)
-	// Waits for the service to stop
-	Wait()
+	// Waits for the service to stop, either because of Stop, ForceStop or
+	// SIGTERM/SIGINT. An error is returned if the server failed or in-flight
+	// requests had to be interrupted.
+	Wait() error
 	// Stops the service gracefully
 	Stop()
 	// Stops the service forcefully
 	ForceStop()
 }
 
+// ServerOptions holds the settings of the servers created by
+// NewNonBlockingGRPCServer. The driver binaries fill them from their command
+// line, see AddServerFlags.
+type ServerOptions struct {
+	TLS TLSOptions
+	// Address of the HTTP listener exposing /metrics, empty disables it.
+	MetricsAddress string
+	// Time given to in-flight requests to finish on SIGTERM before the
+	// server is stopped forcefully.
+	ShutdownGracePeriod time.Duration
+}
+
+var serverOptions = ServerOptions{
+	ShutdownGracePeriod: defaultShutdownGracePeriod,
+}
+
+// AddServerFlags registers the flags setting ServerOptions on fs.
+func AddServerFlags(fs *flag.FlagSet) {
+	fs.StringVar(&serverOptions.TLS.CertFile, "tls-cert-file", "", "TLS certificate of the gRPC server, used for tcp:// endpoints only")
+	fs.StringVar(&serverOptions.TLS.KeyFile, "tls-key-file", "", "TLS private key of the gRPC server, used for tcp:// endpoints only")
+	fs.StringVar(&serverOptions.TLS.ClientCAFile, "tls-client-ca-file", "", "CA bundle to verify client certificates with, enables mutual TLS")
+	fs.StringVar(&serverOptions.MetricsAddress, "metrics-address", "", "address to expose Prometheus metrics on, e.g. :9808, disabled if empty")
+	fs.Var(sensitiveKeysFlag{}, "sensitive-attribute-keys", "comma separated volume attribute keys whose values are not logged")
+	fs.DurationVar(&serverOptions.ShutdownGracePeriod, "shutdown-grace-period", defaultShutdownGracePeriod, "time given to in-flight requests to finish on SIGTERM before they are interrupted")
+}
+
 func NewNonBlockingGRPCServer() NonBlockingGRPCServer {
-	return &nonBlockingGRPCServer{}
+	return &nonBlockingGRPCServer{
+		opts: serverOptions,
+	}
 }
 
 // NonBlocking server
 type nonBlockingGRPCServer struct {
-	wg     sync.WaitGroup
-	server *grpc.Server
+	wg       sync.WaitGroup
+	server   *grpc.Server
+	listener net.Listener
+	metrics  *http.Server
+	opts     ServerOptions
+
+	// Path of the unix socket, removed once the server stopped.
+	socket string
+	// Closed when Serve returned.
+	done chan struct{}
+	// Outcome of the server, reported by Wait.
+	err    error
+	forced bool
 }
 
 func (s *nonBlockingGRPCServer) Start(endpoint string, ids csi.IdentityServer, cs csi.ControllerServer, ns csi.NodeServer) {
 
-	s.wg.Add(1)
+	s.setup(endpoint, ids, cs, ns)
+
+	if s.opts.MetricsAddress != "" {
+		s.metrics = serveMetrics(s.opts.MetricsAddress)
+	}
+
+	s.done = make(chan struct{})
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGTERM, syscall.SIGINT)
+
+	s.wg.Add(2)
 
-	go s.serve(endpoint, ids, cs, ns)
+	go s.serve()
+	go s.handleSignals(sigs)
 
 	return
 }
 
-func (s *nonBlockingGRPCServer) Wait() {
+func (s *nonBlockingGRPCServer) Wait() error {
 	s.wg.Wait()
+	if s.err != nil {
+		return s.err
+	}
+	if s.forced {
+		return fmt.Errorf("in-flight requests did not finish within %v and were interrupted", s.opts.ShutdownGracePeriod)
+	}
+	return nil
 }
 
 func (s *nonBlockingGRPCServer) Stop() {
@@ -70,7 +141,7 @@ func (s *nonBlockingGRPCServer) ForceStop() {
 	s.server.Stop()
 }
 
-func (s *nonBlockingGRPCServer) serve(endpoint string, ids csi.IdentityServer, cs csi.ControllerServer, ns csi.NodeServer) {
+func (s *nonBlockingGRPCServer) setup(endpoint string, ids csi.IdentityServer, cs csi.ControllerServer, ns csi.NodeServer) {
 
 	proto, addr, err := ParseEndpoint(endpoint)
 	if err != nil {
@@ -82,19 +153,45 @@ func (s *nonBlockingGRPCServer) serve(endpoint string, ids csi.IdentityServer, c
 		if err := os.Remove(addr); err != nil && !os.IsNotExist(err) {
 			glog.Fatalf("Failed to remove %s, error: %s", addr, err.Error())
 		}
+		s.socket = addr
 	}
 
 	listener, err := net.Listen(proto, addr)
 	if err != nil {
 		glog.Fatalf("Failed to listen: %v", err)
 	}
+	s.listener = listener
 
 	opts := []grpc.ServerOption{
-		grpc.UnaryInterceptor(logGRPC),
+		grpc.UnaryInterceptor(chainUnaryServer(metricsGRPC, logGRPC)),
+	}
+	if strings.ToLower(proto) == "tcp" {
+		if s.opts.TLS.Enabled() {
+			w, err := newCertWatcher(s.opts.TLS)
+			if err != nil {
+				glog.Fatalf("Failed to set up TLS: %v", err)
+			}
+			opts = append(opts, grpc.Creds(credentials.NewTLS(w.TLSConfig())))
+		} else {
+			glog.Warningf("Serving %s without TLS, clients are not authenticated", endpoint)
+		}
+	} else if s.opts.TLS.Enabled() {
+		glog.Warningf("TLS settings are ignored for endpoint %s", endpoint)
 	}
 	server := grpc.NewServer(opts...)
 	s.server = server
 
+	RegisterServers(server, ids, cs, ns)
+}
+
+// RegisterServers registers the given servers, which may be nil, on server.
+// Unless the driver set them explicitly, the plugin capabilities reported by
+// an identity server built on DefaultIdentityServer are derived from them.
+func RegisterServers(server *grpc.Server, ids csi.IdentityServer, cs csi.ControllerServer, ns csi.NodeServer) {
+	if d, ok := ids.(driverIdentityServer); ok && d.driver() != nil {
+		d.driver().setDefaultPluginCapabilities(cs != nil)
+	}
+
 	if ids != nil {
 		csi.RegisterIdentityServer(server, ids)
 	}
@@ -104,9 +201,56 @@ func (s *nonBlockingGRPCServer) serve(endpoint string, ids csi.IdentityServer, c
 	if ns != nil {
 		csi.RegisterNodeServer(server, ns)
 	}
+}
+
+func (s *nonBlockingGRPCServer) serve() {
+	defer s.wg.Done()
+
+	glog.Infof("Listening for connections on address: %#v", s.listener.Addr())
+
+	if err := s.server.Serve(s.listener); err != nil {
+		s.err = fmt.Errorf("failed to serve: %v", err)
+	}
+	close(s.done)
 
-	glog.Infof("Listening for connections on address: %#v", listener.Addr())
+	if s.metrics != nil {
+		s.metrics.Close()
+	}
+	if s.socket != "" {
+		if err := os.Remove(s.socket); err != nil && !os.IsNotExist(err) {
+			glog.Warningf("Failed to remove socket %s: %v", s.socket, err)
+		}
+	}
+	glog.Infof("Server stopped")
+}
 
-	server.Serve(listener)
+// handleSignals stops the server on SIGTERM or SIGINT, giving in-flight
+// requests the grace period to finish.
+func (s *nonBlockingGRPCServer) handleSignals(sigs chan os.Signal) {
+	defer s.wg.Done()
+	defer signal.Stop(sigs)
 
+	select {
+	case sig := <-sigs:
+		glog.Infof("Received %v, shutting down", sig)
+		s.shutdown()
+	case <-s.done:
+	}
+}
+
+func (s *nonBlockingGRPCServer) shutdown() {
+	stopped := make(chan struct{})
+	go func() {
+		s.server.GracefulStop()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+	case <-time.After(s.opts.ShutdownGracePeriod):
+		glog.Warningf("In-flight requests did not finish within %v, stopping forcefully", s.opts.ShutdownGracePeriod)
+		s.forced = true
+		s.server.Stop()
+		<-stopped
+	}
 }
@@ -0,0 +1,107 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package csicommon
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/container-storage-interface/spec/lib/go/csi/v0"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+)
+
+// blockingIdentityServer does not answer Probe until it is released.
+type blockingIdentityServer struct {
+	*DefaultIdentityServer
+	called  chan struct{}
+	release chan struct{}
+}
+
+func (ids *blockingIdentityServer) Probe(ctx context.Context, req *csi.ProbeRequest) (*csi.ProbeResponse, error) {
+	close(ids.called)
+	select {
+	case <-ids.release:
+	case <-ctx.Done():
+	}
+	return &csi.ProbeResponse{}, nil
+}
+
+func startTestServer(t *testing.T, ids csi.IdentityServer, grace time.Duration) (*nonBlockingGRPCServer, string, func()) {
+	dir, err := ioutil.TempDir("", "csi-server")
+	assert.NoError(t, err)
+	socket := filepath.Join(dir, "csi.sock")
+
+	s := &nonBlockingGRPCServer{opts: ServerOptions{ShutdownGracePeriod: grace}}
+	s.Start("unix://"+socket, ids, nil, nil)
+	return s, socket, func() { os.RemoveAll(dir) }
+}
+
+func dialTestServer(t *testing.T, socket string) *grpc.ClientConn {
+	conn, err := grpc.Dial(socket, grpc.WithInsecure(), grpc.WithDialer(func(addr string, timeout time.Duration) (net.Conn, error) {
+		return net.DialTimeout("unix", addr, timeout)
+	}))
+	assert.NoError(t, err)
+	return conn
+}
+
+func TestServerShutdownOnSignal(t *testing.T) {
+	d := NewCSIDriver(fakeDriverName, vendorVersion, fakeNodeID)
+	s, socket, cleanup := startTestServer(t, NewDefaultIdentityServer(d), time.Minute)
+	defer cleanup()
+
+	_, err := os.Stat(socket)
+	assert.NoError(t, err)
+
+	// No controller server, no controller service advertised
+	assert.Empty(t, d.GetPluginCapabilities())
+
+	assert.NoError(t, syscall.Kill(os.Getpid(), syscall.SIGTERM))
+	assert.NoError(t, s.Wait())
+
+	// The socket is not left behind
+	_, err = os.Stat(socket)
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestServerShutdownGracePeriod(t *testing.T) {
+	d := NewCSIDriver(fakeDriverName, vendorVersion, fakeNodeID)
+	ids := &blockingIdentityServer{
+		DefaultIdentityServer: NewDefaultIdentityServer(d),
+		called:                make(chan struct{}),
+		release:               make(chan struct{}),
+	}
+	defer close(ids.release)
+	s, socket, cleanup := startTestServer(t, ids, 100*time.Millisecond)
+	defer cleanup()
+
+	conn := dialTestServer(t, socket)
+	defer conn.Close()
+	go csi.NewIdentityClient(conn).Probe(context.Background(), &csi.ProbeRequest{})
+	<-ids.called
+
+	// The in-flight request never finishes, the server is stopped forcefully
+	assert.NoError(t, syscall.Kill(os.Getpid(), syscall.SIGTERM))
+	assert.Error(t, s.Wait())
+	assert.True(t, s.forced)
+}
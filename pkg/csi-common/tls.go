@@ -0,0 +1,181 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package csicommon
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+// TLSOptions configures transport security of tcp:// endpoints.
+type TLSOptions struct {
+	// Server certificate and private key, PEM encoded.
+	CertFile string
+	KeyFile  string
+	// CA bundle used to verify client certificates. Setting it turns on
+	// mutual TLS: clients without a valid certificate are rejected.
+	ClientCAFile string
+}
+
+func (o TLSOptions) Enabled() bool {
+	return o.CertFile != "" || o.KeyFile != "" || o.ClientCAFile != ""
+}
+
+func (o TLSOptions) Validate() error {
+	if !o.Enabled() {
+		return nil
+	}
+	if o.CertFile == "" || o.KeyFile == "" {
+		return fmt.Errorf("both a TLS certificate and a key are required")
+	}
+	return nil
+}
+
+// certWatcher serves the certificates configured by TLSOptions and reloads
+// them whenever one of the files changes, so that rotated certificates are
+// picked up without restarting the driver.
+type certWatcher struct {
+	opts TLSOptions
+
+	mux      sync.Mutex
+	cert     *tls.Certificate
+	clientCA *x509.CertPool
+	modTimes map[string]time.Time
+}
+
+func newCertWatcher(opts TLSOptions) (*certWatcher, error) {
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+	w := &certWatcher{opts: opts}
+	if err := w.load(); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+func (w *certWatcher) files() []string {
+	files := []string{w.opts.CertFile, w.opts.KeyFile}
+	if w.opts.ClientCAFile != "" {
+		files = append(files, w.opts.ClientCAFile)
+	}
+	return files
+}
+
+func (w *certWatcher) currentModTimes() (map[string]time.Time, error) {
+	modTimes := map[string]time.Time{}
+	for _, f := range w.files() {
+		fi, err := os.Stat(f)
+		if err != nil {
+			return nil, err
+		}
+		modTimes[f] = fi.ModTime()
+	}
+	return modTimes, nil
+}
+
+func (w *certWatcher) changed(modTimes map[string]time.Time) bool {
+	for f, t := range modTimes {
+		if !w.modTimes[f].Equal(t) {
+			return true
+		}
+	}
+	return false
+}
+
+// load reads the certificate files. Callers must hold the lock, except
+// while the watcher is being created.
+func (w *certWatcher) load() error {
+	modTimes, err := w.currentModTimes()
+	if err != nil {
+		return err
+	}
+
+	cert, err := tls.LoadX509KeyPair(w.opts.CertFile, w.opts.KeyFile)
+	if err != nil {
+		return fmt.Errorf("failed to load TLS certificate: %v", err)
+	}
+
+	var clientCA *x509.CertPool
+	if w.opts.ClientCAFile != "" {
+		pem, err := ioutil.ReadFile(w.opts.ClientCAFile)
+		if err != nil {
+			return fmt.Errorf("failed to read client CA: %v", err)
+		}
+		clientCA = x509.NewCertPool()
+		if !clientCA.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("no certificate found in client CA file %s", w.opts.ClientCAFile)
+		}
+	}
+
+	w.cert = &cert
+	w.clientCA = clientCA
+	w.modTimes = modTimes
+	return nil
+}
+
+// refresh reloads the certificates if any of the files was modified. A
+// failed reload, e.g. because only the certificate has been replaced so far,
+// keeps the previous certificates in use.
+func (w *certWatcher) refresh() {
+	modTimes, err := w.currentModTimes()
+	if err != nil {
+		glog.Warningf("Failed to check TLS certificate files: %v", err)
+		return
+	}
+	if !w.changed(modTimes) {
+		return
+	}
+	if err := w.load(); err != nil {
+		glog.Warningf("Failed to reload TLS certificates, keeping the previous ones: %v", err)
+		return
+	}
+	glog.Infof("Reloaded TLS certificates")
+}
+
+// GetConfigForClient is called for every handshake, see tls.Config.
+func (w *certWatcher) GetConfigForClient(*tls.ClientHelloInfo) (*tls.Config, error) {
+	w.mux.Lock()
+	defer w.mux.Unlock()
+
+	w.refresh()
+
+	config := &tls.Config{
+		Certificates: []tls.Certificate{*w.cert},
+		MinVersion:   tls.VersionTLS12,
+		NextProtos:   []string{"h2"},
+	}
+	if w.clientCA != nil {
+		config.ClientCAs = w.clientCA
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return config, nil
+}
+
+// TLSConfig returns a server configuration backed by the watcher.
+func (w *certWatcher) TLSConfig() *tls.Config {
+	return &tls.Config{
+		GetConfigForClient: w.GetConfigForClient,
+	}
+}
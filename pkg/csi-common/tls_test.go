@@ -0,0 +1,162 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package csicommon
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// writeCert creates a self-signed certificate for commonName and writes it
+// and its key to dir.
+func writeCert(t *testing.T, dir, commonName string) (certFile, keyFile string, cert *x509.Certificate) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: commonName},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	assert.NoError(t, err)
+	cert, err = x509.ParseCertificate(der)
+	assert.NoError(t, err)
+
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	assert.NoError(t, err)
+
+	certFile = filepath.Join(dir, commonName+".crt")
+	keyFile = filepath.Join(dir, commonName+".key")
+	assert.NoError(t, ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600))
+	assert.NoError(t, ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600))
+	return certFile, keyFile, cert
+}
+
+func TestTLSOptionsValidate(t *testing.T) {
+	assert.NoError(t, TLSOptions{}.Validate())
+	assert.NoError(t, TLSOptions{CertFile: "crt", KeyFile: "key"}.Validate())
+	assert.Error(t, TLSOptions{CertFile: "crt"}.Validate())
+	assert.Error(t, TLSOptions{ClientCAFile: "ca"}.Validate())
+}
+
+func TestCertWatcherReload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "csi-tls")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	certFile, keyFile, _ := writeCert(t, dir, "server")
+	w, err := newCertWatcher(TLSOptions{CertFile: certFile, KeyFile: keyFile})
+	assert.NoError(t, err)
+
+	config, err := w.GetConfigForClient(nil)
+	assert.NoError(t, err)
+	assert.Equal(t, tls.NoClientCert, config.ClientAuth)
+	first := config.Certificates[0].Certificate[0]
+
+	// Replace the certificate and make sure the change is visible
+	newCertFile, newKeyFile, _ := writeCert(t, dir, "rotated")
+	assert.NoError(t, os.Rename(newCertFile, certFile))
+	assert.NoError(t, os.Rename(newKeyFile, keyFile))
+	later := time.Now().Add(time.Minute)
+	assert.NoError(t, os.Chtimes(certFile, later, later))
+	assert.NoError(t, os.Chtimes(keyFile, later, later))
+
+	config, err = w.GetConfigForClient(nil)
+	assert.NoError(t, err)
+	assert.NotEqual(t, first, config.Certificates[0].Certificate[0])
+
+	// A broken certificate keeps the previous one in use
+	second := config.Certificates[0].Certificate[0]
+	assert.NoError(t, ioutil.WriteFile(certFile, []byte("garbage"), 0600))
+	later = later.Add(time.Minute)
+	assert.NoError(t, os.Chtimes(certFile, later, later))
+
+	config, err = w.GetConfigForClient(nil)
+	assert.NoError(t, err)
+	assert.Equal(t, second, config.Certificates[0].Certificate[0])
+}
+
+func TestCertWatcherMutualTLS(t *testing.T) {
+	dir, err := ioutil.TempDir("", "csi-tls")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	certFile, keyFile, serverCert := writeCert(t, dir, "server")
+	clientCertFile, clientKeyFile, clientCert := writeCert(t, dir, "client")
+	w, err := newCertWatcher(TLSOptions{CertFile: certFile, KeyFile: keyFile, ClientCAFile: clientCertFile})
+	assert.NoError(t, err)
+
+	roots := x509.NewCertPool()
+	roots.AddCert(serverCert)
+	clientKeyPair, err := tls.LoadX509KeyPair(clientCertFile, clientKeyFile)
+	assert.NoError(t, err)
+
+	handshake := func(clientConfig *tls.Config) error {
+		listener, err := tls.Listen("tcp", "127.0.0.1:0", w.TLSConfig())
+		assert.NoError(t, err)
+		defer listener.Close()
+
+		errs := make(chan error, 1)
+		go func() {
+			conn, err := listener.Accept()
+			if err != nil {
+				errs <- err
+				return
+			}
+			defer conn.Close()
+			errs <- conn.(*tls.Conn).Handshake()
+		}()
+
+		conn, err := tls.Dial("tcp", listener.Addr().String(), clientConfig)
+		if err == nil {
+			defer conn.Close()
+		}
+		// The client may learn about a rejected certificate only when it
+		// reads, the server outcome is what matters here.
+		return <-errs
+	}
+
+	// Client without certificate is rejected
+	err = handshake(&tls.Config{RootCAs: roots, ServerName: "127.0.0.1"})
+	assert.Error(t, err)
+
+	// Client with a certificate signed by the client CA is accepted
+	err = handshake(&tls.Config{RootCAs: roots, ServerName: "127.0.0.1", Certificates: []tls.Certificate{clientKeyPair}})
+	assert.NoError(t, err)
+	assert.Equal(t, "client", clientCert.Subject.CommonName)
+}
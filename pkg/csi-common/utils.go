@@ -68,38 +68,58 @@ func NewControllerServiceCapability(cap csi.ControllerServiceCapability_RPC_Type
 	}
 }
 
-func RunNodePublishServer(endpoint string, d *CSIDriver, ns csi.NodeServer) {
+func NewNodeServiceCapability(cap csi.NodeServiceCapability_RPC_Type) *csi.NodeServiceCapability {
+	return &csi.NodeServiceCapability{
+		Type: &csi.NodeServiceCapability_Rpc{
+			Rpc: &csi.NodeServiceCapability_RPC{
+				Type: cap,
+			},
+		},
+	}
+}
+
+func NewPluginCapability(cap csi.PluginCapability_Service_Type) *csi.PluginCapability {
+	return &csi.PluginCapability{
+		Type: &csi.PluginCapability_Service_{
+			Service: &csi.PluginCapability_Service{
+				Type: cap,
+			},
+		},
+	}
+}
+
+func RunNodePublishServer(endpoint string, d *CSIDriver, ns csi.NodeServer) error {
 	ids := NewDefaultIdentityServer(d)
 
 	s := NewNonBlockingGRPCServer()
 	s.Start(endpoint, ids, nil, ns)
-	s.Wait()
+	return s.Wait()
 }
 
-func RunControllerPublishServer(endpoint string, d *CSIDriver, cs csi.ControllerServer) {
+func RunControllerPublishServer(endpoint string, d *CSIDriver, cs csi.ControllerServer) error {
 	ids := NewDefaultIdentityServer(d)
 
 	s := NewNonBlockingGRPCServer()
 	s.Start(endpoint, ids, cs, nil)
-	s.Wait()
+	return s.Wait()
 }
 
-func RunControllerandNodePublishServer(endpoint string, d *CSIDriver, cs csi.ControllerServer, ns csi.NodeServer) {
+func RunControllerandNodePublishServer(endpoint string, d *CSIDriver, cs csi.ControllerServer, ns csi.NodeServer) error {
 	ids := NewDefaultIdentityServer(d)
 
 	s := NewNonBlockingGRPCServer()
 	s.Start(endpoint, ids, cs, ns)
-	s.Wait()
+	return s.Wait()
 }
 
 func logGRPC(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
 	glog.V(3).Infof("GRPC call: %s", info.FullMethod)
-	glog.V(5).Infof("GRPC request: %+v", req)
+	glog.V(5).Infof("GRPC request: %+v", StripSecrets(req))
 	resp, err := handler(ctx, req)
 	if err != nil {
 		glog.Errorf("GRPC error: %v", err)
 	} else {
-		glog.V(5).Infof("GRPC response: %+v", resp)
+		glog.V(5).Infof("GRPC response: %+v", StripSecrets(resp))
 	}
 	return resp, err
 }
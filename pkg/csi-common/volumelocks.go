@@ -0,0 +1,74 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package csicommon
+
+import (
+	"sync"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// VolumeLocks tracks the volumes with an operation in flight. Keys are
+// whatever identifies the volume for the RPC at hand: the volume name for
+// CreateVolume, the volume ID or the target path otherwise.
+type VolumeLocks struct {
+	mux   sync.Mutex
+	locks map[string]struct{}
+}
+
+func NewVolumeLocks() *VolumeLocks {
+	return &VolumeLocks{
+		locks: map[string]struct{}{},
+	}
+}
+
+// TryAcquire locks all the given keys. If any of them is already locked
+// nothing is locked and false is returned.
+func (vl *VolumeLocks) TryAcquire(keys ...string) bool {
+	vl.mux.Lock()
+	defer vl.mux.Unlock()
+
+	for _, key := range keys {
+		if _, ok := vl.locks[key]; ok {
+			return false
+		}
+	}
+	for _, key := range keys {
+		vl.locks[key] = struct{}{}
+	}
+	return true
+}
+
+// Release unlocks the given keys.
+func (vl *VolumeLocks) Release(keys ...string) {
+	vl.mux.Lock()
+	defer vl.mux.Unlock()
+
+	for _, key := range keys {
+		delete(vl.locks, key)
+	}
+}
+
+// Acquire is TryAcquire returning the Aborted error the CSI spec asks for
+// when an operation is already pending for the volume.
+func (vl *VolumeLocks) Acquire(keys ...string) error {
+	if !vl.TryAcquire(keys...) {
+		return status.Errorf(codes.Aborted, "An operation for volume %v is already in progress", keys)
+	}
+	return nil
+}
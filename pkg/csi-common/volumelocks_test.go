@@ -0,0 +1,61 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package csicommon
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestVolumeLocks(t *testing.T) {
+	vl := NewVolumeLocks()
+
+	assert.True(t, vl.TryAcquire("vol1"))
+	// Duplicate operation on the same volume
+	assert.False(t, vl.TryAcquire("vol1"))
+	// Other volumes are not affected
+	assert.True(t, vl.TryAcquire("vol2"))
+
+	vl.Release("vol1")
+	assert.True(t, vl.TryAcquire("vol1"))
+}
+
+func TestVolumeLocksMultipleKeys(t *testing.T) {
+	vl := NewVolumeLocks()
+
+	assert.True(t, vl.TryAcquire("vol1", "/mnt/target1"))
+	// One of the keys is locked, none of them is acquired
+	assert.False(t, vl.TryAcquire("vol2", "/mnt/target1"))
+	assert.True(t, vl.TryAcquire("vol2"))
+
+	vl.Release("vol1", "/mnt/target1")
+	assert.True(t, vl.TryAcquire("/mnt/target1"))
+}
+
+func TestVolumeLocksAcquire(t *testing.T) {
+	vl := NewVolumeLocks()
+
+	assert.NoError(t, vl.Acquire("vol1"))
+
+	err := vl.Acquire("vol1")
+	s, ok := status.FromError(err)
+	assert.True(t, ok)
+	assert.Equal(t, codes.Aborted, s.Code())
+}
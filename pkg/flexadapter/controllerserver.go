@@ -32,18 +32,23 @@ const (
 type controllerServer struct {
 	flexDriver *flexVolumeDriver
 	*csicommon.DefaultControllerServer
+	volumeLocks *csicommon.VolumeLocks
 }
 
 func (cs *controllerServer) ControllerPublishVolume(ctx context.Context, req *csi.ControllerPublishVolumeRequest) (*csi.ControllerPublishVolumeResponse, error) {
 	if err := cs.Driver.ValidateControllerServiceRequest(csi.ControllerServiceCapability_RPC_PUBLISH_UNPUBLISH_VOLUME); err != nil {
 		return nil, err
 	}
+	if err := cs.volumeLocks.Acquire(req.GetVolumeId()); err != nil {
+		return nil, err
+	}
+	defer cs.volumeLocks.Release(req.GetVolumeId())
 
 	cap := req.GetVolumeCapability()
 	fsType := "ext4"
 	if cap != nil {
 		mount := req.GetVolumeCapability().GetMount()
-		fsType = mount.FsType
+		fsType = mount.GetFsType()
 	}
 
 	call := cs.flexDriver.NewDriverCall(attachCmd)
@@ -70,6 +75,10 @@ func (cs *controllerServer) ControllerUnpublishVolume(ctx context.Context, req *
 	if err := cs.Driver.ValidateControllerServiceRequest(csi.ControllerServiceCapability_RPC_PUBLISH_UNPUBLISH_VOLUME); err != nil {
 		return nil, err
 	}
+	if err := cs.volumeLocks.Acquire(req.GetVolumeId()); err != nil {
+		return nil, err
+	}
+	defer cs.volumeLocks.Release(req.GetVolumeId())
 
 	call := cs.flexDriver.NewDriverCall(detachCmd)
 	call.Append(req.GetVolumeId())
@@ -87,8 +96,8 @@ func (cs *controllerServer) ControllerUnpublishVolume(ctx context.Context, req *
 
 func (cs *controllerServer) ValidateVolumeCapabilities(ctx context.Context, req *csi.ValidateVolumeCapabilitiesRequest) (*csi.ValidateVolumeCapabilitiesResponse, error) {
 	for _, cap := range req.VolumeCapabilities {
-		if cap.GetAccessMode().GetMode() != csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER {
-			return &csi.ValidateVolumeCapabilitiesResponse{Supported: false, Message: ""}, nil
+		if err := cs.Driver.ValidateVolumeCapability(cap); err != nil {
+			return &csi.ValidateVolumeCapabilitiesResponse{Supported: false, Message: status.Convert(err).Message()}, nil
 		}
 	}
 	return &csi.ValidateVolumeCapabilitiesResponse{Supported: true, Message: ""}, nil
@@ -24,6 +24,8 @@ import (
 	"time"
 
 	"github.com/golang/glog"
+
+	"github.com/kubernetes-csi/drivers/pkg/csi-common"
 )
 
 const (
@@ -108,7 +110,30 @@ func (dc *DriverCall) AppendSpec(volumeID, fsType string, readOnly bool, volumeA
 	return nil
 }
 
+// strippedArgs returns the arguments with the sensitive options masked, for
+// logging.
+func (dc *DriverCall) strippedArgs() []string {
+	args := make([]string, len(dc.args))
+	for i, arg := range dc.args {
+		var options OptionsForDriver
+		if err := json.Unmarshal([]byte(arg), &options); err == nil {
+			if jsonBytes, err := json.Marshal(csicommon.StripSensitiveAttributes(options)); err == nil {
+				arg = string(jsonBytes)
+			}
+		}
+		args[i] = arg
+	}
+	return args
+}
+
 func (dc *DriverCall) Run() (*DriverStatus, error) {
+	start := time.Now()
+	status, err := dc.run()
+	observeDriverCall(dc.Command, time.Since(start), err)
+	return status, err
+}
+
+func (dc *DriverCall) run() (*DriverStatus, error) {
 	if dc.driver.isUnsupported(dc.Command) {
 		return nil, errors.New(StatusNotSupported)
 	}
@@ -138,7 +163,7 @@ func (dc *DriverCall) Run() (*DriverStatus, error) {
 		if isCmdNotSupportedErr(err) {
 			dc.driver.unsupported(dc.Command)
 		} else {
-			glog.Warningf("FlexVolume: driver call failed: executable: %s, args: %s, error: %s, output: %q", execPath, dc.args, execErr.Error(), output)
+			glog.Warningf("FlexVolume: driver call failed: executable: %s, args: %s, error: %s, output: %q", execPath, dc.strippedArgs(), execErr.Error(), output)
 		}
 		return nil, err
 	}
@@ -49,6 +49,7 @@ func NewControllerServer(d *csicommon.CSIDriver, f *flexVolumeDriver) *controlle
 	return &controllerServer{
 		flexDriver:              f,
 		DefaultControllerServer: csicommon.NewDefaultControllerServer(d),
+		volumeLocks:             csicommon.NewVolumeLocks(),
 	}
 }
 
@@ -56,10 +57,12 @@ func NewNodeServer(d *csicommon.CSIDriver, f *flexVolumeDriver) *nodeServer {
 	return &nodeServer{
 		flexDriver:        f,
 		DefaultNodeServer: csicommon.NewDefaultNodeServer(d),
+		volumeLocks:       csicommon.NewVolumeLocks(),
+		mounter:           csicommon.NewMounter(),
 	}
 }
 
-func (f *flexAdapter) Run(driverName, driverPath, nodeID, endpoint string) {
+func (f *flexAdapter) Run(driverName, driverPath, nodeID, endpoint string) error {
 	var err error
 
 	glog.Infof("Driver: %v version: %v", driverName, version)
@@ -75,12 +78,19 @@ func (f *flexAdapter) Run(driverName, driverPath, nodeID, endpoint string) {
 	f.driver = csicommon.NewCSIDriver(driverName, version, nodeID)
 	if f.flexDriver.capabilities.Attach {
 		f.driver.AddControllerServiceCapabilities([]csi.ControllerServiceCapability_RPC_Type{csi.ControllerServiceCapability_RPC_PUBLISH_UNPUBLISH_VOLUME})
+		// Only the devices of attachable drivers can be published raw
+		f.driver.EnableBlockVolumes()
 	}
 	f.driver.AddVolumeCapabilityAccessModes([]csi.VolumeCapability_AccessMode_Mode{csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER})
+	csicommon.AddSensitiveAttributeKeys(optionKeySecret)
+	f.driver.AddHealthCheck("init", func() error {
+		_, err := f.flexDriver.NewDriverCall(initCmd).Run()
+		return err
+	})
 
 	// Create GRPC servers
 	f.ns = NewNodeServer(f.driver, f.flexDriver)
 	f.cs = NewControllerServer(f.driver, f.flexDriver)
 
-	csicommon.RunControllerandNodePublishServer(endpoint, f.driver, f.cs, f.ns)
+	return csicommon.RunControllerandNodePublishServer(endpoint, f.driver, f.cs, f.ns)
 }
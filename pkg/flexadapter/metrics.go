@@ -0,0 +1,53 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package flexadapter
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/kubernetes-csi/drivers/pkg/csi-common"
+)
+
+var driverCallDuration = prometheus.NewHistogramVec(
+	prometheus.HistogramOpts{
+		Namespace: csicommon.MetricsNamespace,
+		Subsystem: "flexadapter",
+		Name:      "driver_call_duration_seconds",
+		Help:      "Duration of the FlexVolume driver callouts by command and result.",
+		Buckets:   []float64{.01, .05, .1, .5, 1, 5, 10, 30, 60, 120, 300},
+	},
+	[]string{"command", "status"},
+)
+
+func init() {
+	prometheus.MustRegister(driverCallDuration)
+}
+
+func observeDriverCall(command string, duration time.Duration, err error) {
+	status := "success"
+	switch {
+	case err == TimeoutError:
+		status = "timeout"
+	case isCmdNotSupportedErr(err):
+		status = "not_supported"
+	case err != nil:
+		status = "failure"
+	}
+	driverCallDuration.WithLabelValues(command, status).Observe(duration.Seconds())
+}
@@ -17,14 +17,10 @@ limitations under the License.
 package flexadapter
 
 import (
-	"os"
-
 	"github.com/container-storage-interface/spec/lib/go/csi/v0"
 	"golang.org/x/net/context"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
-	"k8s.io/kubernetes/pkg/util/mount"
-	"k8s.io/kubernetes/pkg/volume/util"
 
 	"github.com/kubernetes-csi/drivers/pkg/csi-common"
 )
@@ -32,24 +28,12 @@ import (
 type nodeServer struct {
 	flexDriver *flexVolumeDriver
 	*csicommon.DefaultNodeServer
+	volumeLocks *csicommon.VolumeLocks
+	mounter     *csicommon.Mounter
 }
 
-func mountDevice(devicePath, targetPath, fsType string, readOnly bool, mountOptions []string) error {
-	var options []string
-
-	if readOnly {
-		options = append(options, "ro")
-	} else {
-		options = append(options, "rw")
-	}
-	options = append(options, mountOptions...)
-
-	diskMounter := &mount.SafeFormatAndMount{Interface: mount.New(""), Exec: mount.NewOsExec()}
-
-	return diskMounter.FormatAndMount(devicePath, targetPath, fsType, options)
-}
-
-func (ns *nodeServer) waitForAttach(req *csi.NodePublishVolumeRequest, fsType string) error {
+// waitForAttach waits for the attached device and returns its path.
+func (ns *nodeServer) waitForAttach(req *csi.NodePublishVolumeRequest, fsType string) (string, error) {
 
 	var dID string
 
@@ -57,46 +41,73 @@ func (ns *nodeServer) waitForAttach(req *csi.NodePublishVolumeRequest, fsType st
 		var ok bool
 		dID, ok = req.GetPublishInfo()[deviceID]
 		if !ok {
-			return status.Error(codes.InvalidArgument, "Missing device ID")
+			return "", status.Error(codes.InvalidArgument, "Missing device ID")
 		}
 	} else {
-		return status.Error(codes.InvalidArgument, "Missing publish info and device ID")
+		return "", status.Error(codes.InvalidArgument, "Missing publish info and device ID")
 	}
 
 	call := ns.flexDriver.NewDriverCall(waitForAttachCmd)
 	call.Append(dID)
 	call.AppendSpec(req.GetVolumeId(), fsType, req.GetReadonly(), req.GetVolumeAttributes())
 
-	_, err := call.Run()
+	callStatus, err := call.Run()
 	if isCmdNotSupportedErr(err) {
-		return nil
+		return dID, nil
 	}
 
 	if err != nil {
-		status.Error(codes.Internal, err.Error())
+		return "", status.Error(codes.Internal, err.Error())
+	}
+
+	if callStatus.DevicePath != "" {
+		return callStatus.DevicePath, nil
 	}
+	return dID, nil
+}
 
-	return nil
+// publishBlock bind mounts the device of an attachable volume on the
+// target path, flex volume drivers can only mount file systems.
+func (ns *nodeServer) publishBlock(req *csi.NodePublishVolumeRequest) (*csi.NodePublishVolumeResponse, error) {
+	devicePath, err := ns.waitForAttach(req, "")
+	if err != nil {
+		return nil, err
+	}
+	if err := ns.mounter.MountBlock(devicePath, req.GetTargetPath(), req.GetReadonly()); err != nil {
+		return nil, err
+	}
+	return &csi.NodePublishVolumeResponse{}, nil
 }
 
 func (ns *nodeServer) NodePublishVolume(ctx context.Context, req *csi.NodePublishVolumeRequest) (*csi.NodePublishVolumeResponse, error) {
 
 	targetPath := req.GetTargetPath()
 	fsType := req.GetVolumeCapability().GetMount().GetFsType()
+	if req.GetVolumeCapability() == nil {
+		return nil, status.Error(codes.InvalidArgument, "Volume capability missing in request")
+	}
+	if err := ns.Driver.ValidateVolumeCapability(req.GetVolumeCapability()); err != nil {
+		return nil, err
+	}
+	if err := ns.volumeLocks.Acquire(targetPath); err != nil {
+		return nil, err
+	}
+	defer ns.volumeLocks.Release(targetPath)
 
-	notMnt, err := mount.New("").IsLikelyNotMountPoint(targetPath)
-	if err != nil {
-		if os.IsNotExist(err) {
-			if err := os.MkdirAll(targetPath, 0750); err != nil {
-				return nil, status.Error(codes.Internal, err.Error())
-			}
-			notMnt = true
-		} else {
-			return nil, status.Error(codes.Internal, err.Error())
-		}
+	if req.GetVolumeCapability().GetBlock() != nil {
+		return ns.publishBlock(req)
 	}
 
-	if !notMnt {
+	options := csicommon.MountOptions(req.GetReadonly(), req.GetVolumeCapability().GetMount().GetMountFlags())
+	mounted, err := ns.mounter.PrepareTarget(targetPath, false)
+	if err != nil {
+		return nil, err
+	}
+	if mounted {
+		// The flex volume driver mounted the volume, its source is unknown
+		if err := ns.mounter.CheckMount(targetPath, fsType, options); err != nil {
+			return nil, err
+		}
 		return &csi.NodePublishVolumeResponse{}, nil
 	}
 
@@ -104,7 +115,7 @@ func (ns *nodeServer) NodePublishVolume(ctx context.Context, req *csi.NodePublis
 
 	// Attachable driver.
 	if ns.flexDriver.capabilities.Attach {
-		err = ns.waitForAttach(req, fsType)
+		_, err = ns.waitForAttach(req, fsType)
 		if err != nil {
 			return nil, err
 		}
@@ -123,10 +134,8 @@ func (ns *nodeServer) NodePublishVolume(ctx context.Context, req *csi.NodePublis
 	call.AppendSpec(req.GetVolumeId(), fsType, req.GetReadonly(), req.GetVolumeAttributes())
 	_, err = call.Run()
 	if isCmdNotSupportedErr(err) {
-		mountFlags := req.GetVolumeCapability().GetMount().GetMountFlags()
-		err := mountDevice(req.VolumeAttributes[deviceID], targetPath, fsType, req.GetReadonly(), mountFlags)
-		if err != nil {
-			return nil, status.Error(codes.Internal, err.Error())
+		if err := ns.mounter.FormatAndMount(req.VolumeAttributes[deviceID], targetPath, fsType, options); err != nil {
+			return nil, err
 		}
 	} else if err != nil {
 		return nil, status.Error(codes.Internal, err.Error())
@@ -135,11 +144,23 @@ func (ns *nodeServer) NodePublishVolume(ctx context.Context, req *csi.NodePublis
 	return &csi.NodePublishVolumeResponse{}, nil
 }
 
-func unmountDevice(path string) error {
-	return util.UnmountPath(path, mount.New(""))
-}
-
 func (ns *nodeServer) NodeUnpublishVolume(ctx context.Context, req *csi.NodeUnpublishVolumeRequest) (*csi.NodeUnpublishVolumeResponse, error) {
+	if err := ns.volumeLocks.Acquire(req.GetTargetPath()); err != nil {
+		return nil, err
+	}
+	defer ns.volumeLocks.Release(req.GetTargetPath())
+
+	// Block volumes are bind mounted by the adapter itself
+	isBlock, err := csicommon.IsBlockTarget(req.GetTargetPath())
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	if isBlock {
+		if err := ns.mounter.Unmount(req.GetTargetPath()); err != nil {
+			return nil, err
+		}
+		return &csi.NodeUnpublishVolumeResponse{}, nil
+	}
 
 	var call *DriverCall
 	if ns.flexDriver.capabilities.Attach {
@@ -149,10 +170,11 @@ func (ns *nodeServer) NodeUnpublishVolume(ctx context.Context, req *csi.NodeUnpu
 	}
 	call.Append(req.GetTargetPath())
 
-	_, err := call.Run()
+	_, err = call.Run()
 	if isCmdNotSupportedErr(err) {
-		err := unmountDevice(req.GetTargetPath())
-		return nil, status.Error(codes.Internal, err.Error())
+		if err := ns.mounter.Unmount(req.GetTargetPath()); err != nil {
+			return nil, err
+		}
 	} else if err != nil {
 		return nil, status.Error(codes.Internal, err.Error())
 	}
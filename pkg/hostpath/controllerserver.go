@@ -38,11 +38,12 @@ const (
 
 type controllerServer struct {
 	*csicommon.DefaultControllerServer
+	volumeLocks *csicommon.VolumeLocks
 }
 
 func (cs *controllerServer) CreateVolume(ctx context.Context, req *csi.CreateVolumeRequest) (*csi.CreateVolumeResponse, error) {
 	if err := cs.Driver.ValidateControllerServiceRequest(csi.ControllerServiceCapability_RPC_CREATE_DELETE_VOLUME); err != nil {
-		glog.V(3).Infof("invalid create volume req: %v", req)
+		glog.V(3).Infof("invalid create volume req: %v", csicommon.StripSecrets(req))
 		return nil, err
 	}
 
@@ -53,6 +54,11 @@ func (cs *controllerServer) CreateVolume(ctx context.Context, req *csi.CreateVol
 	if req.GetVolumeCapabilities() == nil {
 		return nil, status.Error(codes.InvalidArgument, "Volume Capabilities missing in request")
 	}
+	if err := cs.volumeLocks.Acquire(req.GetName()); err != nil {
+		return nil, err
+	}
+	defer cs.volumeLocks.Release(req.GetName())
+
 	// Need to check for already existing volume name, and if found
 	// check for the requested capacity and already allocated capacity
 	if exVol, err := getVolumeByName(req.GetName()); err == nil {
@@ -90,7 +96,11 @@ func (cs *controllerServer) CreateVolume(ctx context.Context, req *csi.CreateVol
 	hostPathVol.VolID = volumeID
 	hostPathVol.VolSize = capacity
 	hostPathVol.VolPath = path
-	hostPathVolumes[volumeID] = hostPathVol
+	if err := hostPathVolumes.add(hostPathVol); err != nil {
+		glog.V(3).Infof("failed to record volume %s: %v", volumeID, err)
+		os.RemoveAll(path)
+		return nil, status.Error(codes.Internal, err.Error())
+	}
 	return &csi.CreateVolumeResponse{
 		Volume: &csi.Volume{
 			Id:            volumeID,
@@ -108,14 +118,24 @@ func (cs *controllerServer) DeleteVolume(ctx context.Context, req *csi.DeleteVol
 	}
 
 	if err := cs.Driver.ValidateControllerServiceRequest(csi.ControllerServiceCapability_RPC_CREATE_DELETE_VOLUME); err != nil {
-		glog.V(3).Infof("invalid delete volume req: %v", req)
+		glog.V(3).Infof("invalid delete volume req: %v", csicommon.StripSecrets(req))
 		return nil, err
 	}
 	volumeID := req.VolumeId
+	if err := cs.volumeLocks.Acquire(volumeID); err != nil {
+		return nil, err
+	}
+	defer cs.volumeLocks.Release(volumeID)
+
 	glog.V(4).Infof("deleting volume %s", volumeID)
+	// Drop the record first so that a crash leaves at most an orphaned
+	// directory behind, which is reported on the next start.
+	if err := hostPathVolumes.remove(volumeID); err != nil {
+		glog.V(3).Infof("failed to remove volume record %s: %v", volumeID, err)
+		return nil, status.Error(codes.Internal, err.Error())
+	}
 	path := provisionRoot + volumeID
 	os.RemoveAll(path)
-	delete(hostPathVolumes, volumeID)
 	return &csi.DeleteVolumeResponse{}, nil
 }
 
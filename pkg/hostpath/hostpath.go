@@ -17,8 +17,6 @@ limitations under the License.
 package hostpath
 
 import (
-	"fmt"
-
 	"github.com/container-storage-interface/spec/lib/go/csi/v0"
 	"github.com/golang/glog"
 
@@ -52,7 +50,7 @@ type hostPathVolume struct {
 	VolPath string `json:"volPath"`
 }
 
-var hostPathVolumes map[string]hostPathVolume
+var hostPathVolumes *volumeStore
 
 var (
 	hostPathDriver *hostPath
@@ -60,7 +58,7 @@ var (
 )
 
 func init() {
-	hostPathVolumes = map[string]hostPathVolume{}
+	hostPathVolumes, _ = newVolumeStore("")
 }
 
 func GetHostPathDriver() *hostPath {
@@ -76,25 +74,34 @@ func NewIdentityServer(d *csicommon.CSIDriver) *identityServer {
 func NewControllerServer(d *csicommon.CSIDriver) *controllerServer {
 	return &controllerServer{
 		DefaultControllerServer: csicommon.NewDefaultControllerServer(d),
+		volumeLocks:             csicommon.NewVolumeLocks(),
 	}
 }
 
 func NewNodeServer(d *csicommon.CSIDriver) *nodeServer {
 	return &nodeServer{
 		DefaultNodeServer: csicommon.NewDefaultNodeServer(d),
+		volumeLocks:       csicommon.NewVolumeLocks(),
+		mounter:           csicommon.NewMounter(),
 	}
 }
 
-func (hp *hostPath) Run(driverName, nodeID, endpoint string) {
+func (hp *hostPath) Run(driverName, nodeID, endpoint, stateDir string) error {
 	glog.Infof("Driver: %v ", driverName)
 
+	// Reload the volumes created before the last restart
+	store, err := newVolumeStore(stateDir)
+	if err != nil {
+		glog.Fatalf("Failed to load volume store: %v", err)
+	}
+	hostPathVolumes = store
+	reconcileVolumes(store)
+
 	// Initialize default library driver
-	hp.driver = csicommon.NewCSIDriver(driverName, vendorVersion, nodeID)
+	hp.driver = newCSIDriver(driverName, nodeID)
 	if hp.driver == nil {
 		glog.Fatalln("Failed to initialize CSI Driver.")
 	}
-	hp.driver.AddControllerServiceCapabilities([]csi.ControllerServiceCapability_RPC_Type{csi.ControllerServiceCapability_RPC_CREATE_DELETE_VOLUME})
-	hp.driver.AddVolumeCapabilityAccessModes([]csi.VolumeCapability_AccessMode_Mode{csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER})
 
 	// Create GRPC servers
 	hp.ids = NewIdentityServer(hp.driver)
@@ -103,21 +110,42 @@ func (hp *hostPath) Run(driverName, nodeID, endpoint string) {
 
 	s := csicommon.NewNonBlockingGRPCServer()
 	s.Start(endpoint, hp.ids, hp.cs, hp.ns)
-	s.Wait()
+	return s.Wait()
 }
 
-func getVolumeByID(volumeID string) (hostPathVolume, error) {
-	if hostPathVol, ok := hostPathVolumes[volumeID]; ok {
-		return hostPathVol, nil
+func newCSIDriver(driverName, nodeID string) *csicommon.CSIDriver {
+	d := csicommon.NewCSIDriver(driverName, vendorVersion, nodeID)
+	if d == nil {
+		return nil
 	}
-	return hostPathVolume{}, fmt.Errorf("volume id %s does not exit in the volumes list", volumeID)
+	d.AddControllerServiceCapabilities([]csi.ControllerServiceCapability_RPC_Type{csi.ControllerServiceCapability_RPC_CREATE_DELETE_VOLUME})
+	d.AddVolumeCapabilityAccessModes([]csi.VolumeCapability_AccessMode_Mode{csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER})
+	d.AddHealthCheck("provisionRoot", csicommon.WritableDirHealthCheck(provisionRoot))
+	return d
 }
 
-func getVolumeByName(volName string) (hostPathVolume, error) {
-	for _, hostPathVol := range hostPathVolumes {
-		if hostPathVol.VolName == volName {
-			return hostPathVol, nil
-		}
+// reconcileVolumes reports volume directories without a record and records
+// without a directory. Nothing is removed, the admin has to decide what to
+// do with them.
+func reconcileVolumes(store *volumeStore) {
+	orphans, dangling, err := store.reconcile(provisionRoot)
+	if err != nil {
+		glog.Errorf("Failed to reconcile volumes under %s: %v", provisionRoot, err)
+		return
+	}
+	for _, path := range orphans {
+		glog.Warningf("Found orphaned volume directory %s with no volume record", path)
+	}
+	for _, volumeID := range dangling {
+		glog.Warningf("Found volume record %s whose directory does not exist", volumeID)
 	}
-	return hostPathVolume{}, fmt.Errorf("volume name %s does not exit in the volumes list", volName)
+	glog.Infof("Loaded %d volume(s), %d orphaned directories, %d dangling records", len(store.list()), len(orphans), len(dangling))
+}
+
+func getVolumeByID(volumeID string) (hostPathVolume, error) {
+	return hostPathVolumes.getVolumeByID(volumeID)
+}
+
+func getVolumeByName(volName string) (hostPathVolume, error) {
+	return hostPathVolumes.getVolumeByName(volName)
 }
@@ -0,0 +1,35 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package hostpath
+
+import (
+	"testing"
+
+	"github.com/kubernetes-csi/drivers/pkg/csi-common/csitest"
+)
+
+func TestConformance(t *testing.T) {
+	d := newCSIDriver("csi-hostpath", "fakeNodeID")
+
+	csitest.Run(t, csitest.Config{
+		Identity:   NewIdentityServer(d),
+		Controller: NewControllerServer(d),
+		Node:       NewNodeServer(d),
+		// Bind mounting the volumes needs root privileges
+		Skip: []string{"Node/PublishUnpublish"},
+	})
+}
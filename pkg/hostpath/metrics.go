@@ -0,0 +1,54 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package hostpath
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/kubernetes-csi/drivers/pkg/csi-common"
+)
+
+func init() {
+	prometheus.MustRegister(
+		prometheus.NewGaugeFunc(
+			prometheus.GaugeOpts{
+				Namespace: csicommon.MetricsNamespace,
+				Subsystem: "hostpath",
+				Name:      "volumes",
+				Help:      "Number of provisioned volumes.",
+			},
+			func() float64 {
+				return float64(len(hostPathVolumes.list()))
+			},
+		),
+		prometheus.NewGaugeFunc(
+			prometheus.GaugeOpts{
+				Namespace: csicommon.MetricsNamespace,
+				Subsystem: "hostpath",
+				Name:      "volume_bytes",
+				Help:      "Total capacity of the provisioned volumes in bytes.",
+			},
+			func() float64 {
+				var total int64
+				for _, vol := range hostPathVolumes.list() {
+					total += vol.VolSize
+				}
+				return float64(total)
+			},
+		),
+	)
+}
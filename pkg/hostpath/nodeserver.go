@@ -17,21 +17,20 @@ limitations under the License.
 package hostpath
 
 import (
-	"os"
-
 	"github.com/golang/glog"
 	"golang.org/x/net/context"
 
 	"github.com/container-storage-interface/spec/lib/go/csi/v0"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
-	"k8s.io/kubernetes/pkg/util/mount"
 
 	"github.com/kubernetes-csi/drivers/pkg/csi-common"
 )
 
 type nodeServer struct {
 	*csicommon.DefaultNodeServer
+	volumeLocks *csicommon.VolumeLocks
+	mounter     *csicommon.Mounter
 }
 
 func (ns *nodeServer) NodePublishVolume(ctx context.Context, req *csi.NodePublishVolumeRequest) (*csi.NodePublishVolumeResponse, error) {
@@ -48,21 +47,10 @@ func (ns *nodeServer) NodePublishVolume(ctx context.Context, req *csi.NodePublis
 	}
 
 	targetPath := req.GetTargetPath()
-	notMnt, err := mount.New("").IsLikelyNotMountPoint(targetPath)
-	if err != nil {
-		if os.IsNotExist(err) {
-			if err = os.MkdirAll(targetPath, 0750); err != nil {
-				return nil, status.Error(codes.Internal, err.Error())
-			}
-			notMnt = true
-		} else {
-			return nil, status.Error(codes.Internal, err.Error())
-		}
-	}
-
-	if !notMnt {
-		return &csi.NodePublishVolumeResponse{}, nil
+	if err := ns.volumeLocks.Acquire(targetPath); err != nil {
+		return nil, err
 	}
+	defer ns.volumeLocks.Release(targetPath)
 
 	fsType := req.GetVolumeCapability().GetMount().GetFsType()
 
@@ -76,16 +64,12 @@ func (ns *nodeServer) NodePublishVolume(ctx context.Context, req *csi.NodePublis
 	attrib := req.GetVolumeAttributes()
 	mountFlags := req.GetVolumeCapability().GetMount().GetMountFlags()
 
-	glog.V(4).Infof("target %v\nfstype %v\ndevice %v\nreadonly %v\nattributes %v\n mountflags %v\n",
-		targetPath, fsType, deviceId, readOnly, volumeId, attrib, mountFlags)
+	glog.V(4).Infof("target %v\nfstype %v\ndevice %v\nreadonly %v\nvolumeId %v\nattributes %v\nmountflags %v\n",
+		targetPath, fsType, deviceId, readOnly, volumeId, csicommon.StripSensitiveAttributes(attrib), mountFlags)
 
-	options := []string{"bind"}
-	if readOnly {
-		options = append(options, "ro")
-	}
-	mounter := mount.New("")
+	options := csicommon.MountOptions(readOnly, mountFlags, "bind")
 	path := provisionRoot + volumeId
-	if err := mounter.Mount(path, targetPath, "", options); err != nil {
+	if err := ns.mounter.Mount(path, targetPath, "", options); err != nil {
 		return nil, err
 	}
 
@@ -103,11 +87,14 @@ func (ns *nodeServer) NodeUnpublishVolume(ctx context.Context, req *csi.NodeUnpu
 	}
 	targetPath := req.GetTargetPath()
 	volumeID := req.GetVolumeId()
+	if err := ns.volumeLocks.Acquire(targetPath); err != nil {
+		return nil, err
+	}
+	defer ns.volumeLocks.Release(targetPath)
 
 	// Unmounting the image
-	err := mount.New("").Unmount(req.GetTargetPath())
-	if err != nil {
-		return nil, status.Error(codes.Internal, err.Error())
+	if err := ns.mounter.Unmount(targetPath); err != nil {
+		return nil, err
 	}
 	glog.V(4).Infof("hostpath: volume %s/%s has been unmounted.", targetPath, volumeID)
 
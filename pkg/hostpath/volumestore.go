@@ -0,0 +1,220 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package hostpath
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/golang/glog"
+	"github.com/pborman/uuid"
+)
+
+const (
+	volumeStoreFile = "volumes.json"
+)
+
+// volumeStore holds the hostpath volume records. When it is backed by a
+// state directory every change is written to disk before it becomes
+// visible, so the records survive a restart of the plugin.
+type volumeStore struct {
+	sync.Mutex
+	file    string
+	volumes map[string]hostPathVolume
+}
+
+// newVolumeStore loads the volume records persisted in stateDir. An empty
+// stateDir gives a store that is kept in memory only.
+func newVolumeStore(stateDir string) (*volumeStore, error) {
+	s := &volumeStore{
+		volumes: map[string]hostPathVolume{},
+	}
+	if stateDir == "" {
+		return s, nil
+	}
+
+	if err := os.MkdirAll(stateDir, 0750); err != nil {
+		return nil, fmt.Errorf("failed to create state directory %s: %v", stateDir, err)
+	}
+	s.file = filepath.Join(stateDir, volumeStoreFile)
+
+	data, err := ioutil.ReadFile(s.file)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return s, nil
+		}
+		return nil, fmt.Errorf("failed to read volume store %s: %v", s.file, err)
+	}
+	if err := json.Unmarshal(data, &s.volumes); err != nil {
+		return nil, fmt.Errorf("failed to decode volume store %s: %v", s.file, err)
+	}
+	if s.volumes == nil {
+		s.volumes = map[string]hostPathVolume{}
+	}
+	return s, nil
+}
+
+func (s *volumeStore) getVolumeByID(volumeID string) (hostPathVolume, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	if hostPathVol, ok := s.volumes[volumeID]; ok {
+		return hostPathVol, nil
+	}
+	return hostPathVolume{}, fmt.Errorf("volume id %s does not exit in the volumes list", volumeID)
+}
+
+func (s *volumeStore) getVolumeByName(volName string) (hostPathVolume, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	for _, hostPathVol := range s.volumes {
+		if hostPathVol.VolName == volName {
+			return hostPathVol, nil
+		}
+	}
+	return hostPathVolume{}, fmt.Errorf("volume name %s does not exit in the volumes list", volName)
+}
+
+func (s *volumeStore) list() []hostPathVolume {
+	s.Lock()
+	defer s.Unlock()
+
+	vols := make([]hostPathVolume, 0, len(s.volumes))
+	for _, hostPathVol := range s.volumes {
+		vols = append(vols, hostPathVol)
+	}
+	return vols
+}
+
+// add records the volume. The in-memory state is left untouched if the
+// record cannot be persisted.
+func (s *volumeStore) add(vol hostPathVolume) error {
+	s.Lock()
+	defer s.Unlock()
+
+	old, existed := s.volumes[vol.VolID]
+	s.volumes[vol.VolID] = vol
+	if err := s.persist(); err != nil {
+		if existed {
+			s.volumes[vol.VolID] = old
+		} else {
+			delete(s.volumes, vol.VolID)
+		}
+		return err
+	}
+	return nil
+}
+
+// remove deletes the volume record. Removing an unknown volume is not an
+// error.
+func (s *volumeStore) remove(volumeID string) error {
+	s.Lock()
+	defer s.Unlock()
+
+	old, existed := s.volumes[volumeID]
+	if !existed {
+		return nil
+	}
+	delete(s.volumes, volumeID)
+	if err := s.persist(); err != nil {
+		s.volumes[volumeID] = old
+		return err
+	}
+	return nil
+}
+
+// persist atomically replaces the store file with the current records: the
+// data is written and synced to a temporary file which is then renamed over
+// the old one. Callers must hold the lock. Once the rename succeeded the new
+// records are in place, so a failure to sync the directory is only logged.
+func (s *volumeStore) persist() error {
+	if s.file == "" {
+		return nil
+	}
+
+	data, err := json.Marshal(s.volumes)
+	if err != nil {
+		return fmt.Errorf("failed to encode volume store: %v", err)
+	}
+
+	tmp := s.file + ".tmp"
+	fp, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0640)
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %v", tmp, err)
+	}
+	if _, err = fp.Write(data); err == nil {
+		err = fp.Sync()
+	}
+	if closeErr := fp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to write %s: %v", tmp, err)
+	}
+	if err := os.Rename(tmp, s.file); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to rename %s to %s: %v", tmp, s.file, err)
+	}
+
+	// Sync the directory so that the rename itself is durable.
+	dir, err := os.Open(filepath.Dir(s.file))
+	if err != nil {
+		glog.Warningf("failed to open state directory to sync %s: %v", s.file, err)
+		return nil
+	}
+	defer dir.Close()
+	if err := dir.Sync(); err != nil {
+		glog.Warningf("failed to sync state directory of %s: %v", s.file, err)
+	}
+	return nil
+}
+
+// reconcile compares the records with the volume directories under root.
+// It returns the directories that look like volumes but have no record
+// (orphans) and the IDs of records whose directory is gone (dangling).
+func (s *volumeStore) reconcile(root string) (orphans []string, dangling []string, err error) {
+	s.Lock()
+	defer s.Unlock()
+
+	for id, vol := range s.volumes {
+		if _, statErr := os.Stat(vol.VolPath); os.IsNotExist(statErr) {
+			dangling = append(dangling, id)
+		}
+	}
+
+	entries, err := ioutil.ReadDir(root)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, entry := range entries {
+		// Volume directories are named after the volume ID, which is a
+		// UUID. Anything else under root does not belong to us.
+		if !entry.IsDir() || uuid.Parse(entry.Name()) == nil {
+			continue
+		}
+		if _, ok := s.volumes[entry.Name()]; !ok {
+			orphans = append(orphans, filepath.Join(root, entry.Name()))
+		}
+	}
+	return orphans, dangling, nil
+}
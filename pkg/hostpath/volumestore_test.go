@@ -0,0 +1,110 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package hostpath
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/pborman/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+// Test that records survive reopening the store
+func TestVolumeStorePersistence(t *testing.T) {
+	stateDir, err := ioutil.TempDir("", "hostpath-state")
+	assert.NoError(t, err)
+	defer os.RemoveAll(stateDir)
+
+	s, err := newVolumeStore(stateDir)
+	assert.NoError(t, err)
+
+	vol := hostPathVolume{VolName: "vol1", VolID: "id1", VolSize: gib, VolPath: "/tmp/id1"}
+	assert.NoError(t, s.add(vol))
+	assert.NoError(t, s.add(hostPathVolume{VolName: "vol2", VolID: "id2"}))
+	assert.NoError(t, s.remove("id2"))
+	// Removing an unknown volume is fine
+	assert.NoError(t, s.remove("id3"))
+
+	reopened, err := newVolumeStore(stateDir)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(reopened.list()))
+
+	got, err := reopened.getVolumeByName("vol1")
+	assert.NoError(t, err)
+	assert.Equal(t, vol, got)
+
+	_, err = reopened.getVolumeByID("id2")
+	assert.Error(t, err)
+}
+
+// Test that a store without state directory keeps records in memory only
+func TestVolumeStoreInMemory(t *testing.T) {
+	s, err := newVolumeStore("")
+	assert.NoError(t, err)
+
+	assert.NoError(t, s.add(hostPathVolume{VolName: "vol1", VolID: "id1"}))
+	got, err := s.getVolumeByID("id1")
+	assert.NoError(t, err)
+	assert.Equal(t, "vol1", got.VolName)
+}
+
+// Test that a corrupted store file is reported instead of being ignored
+func TestVolumeStoreCorrupted(t *testing.T) {
+	stateDir, err := ioutil.TempDir("", "hostpath-state")
+	assert.NoError(t, err)
+	defer os.RemoveAll(stateDir)
+
+	err = ioutil.WriteFile(filepath.Join(stateDir, volumeStoreFile), []byte("{"), 0640)
+	assert.NoError(t, err)
+
+	_, err = newVolumeStore(stateDir)
+	assert.Error(t, err)
+}
+
+// Test reconcile
+func TestVolumeStoreReconcile(t *testing.T) {
+	root, err := ioutil.TempDir("", "hostpath-root")
+	assert.NoError(t, err)
+	defer os.RemoveAll(root)
+
+	s, err := newVolumeStore("")
+	assert.NoError(t, err)
+
+	// Volume with record and directory
+	okID := uuid.NewUUID().String()
+	assert.NoError(t, os.Mkdir(filepath.Join(root, okID), 0750))
+	assert.NoError(t, s.add(hostPathVolume{VolID: okID, VolPath: filepath.Join(root, okID)}))
+
+	// Record without directory
+	danglingID := uuid.NewUUID().String()
+	assert.NoError(t, s.add(hostPathVolume{VolID: danglingID, VolPath: filepath.Join(root, danglingID)}))
+
+	// Directory without record
+	orphanID := uuid.NewUUID().String()
+	assert.NoError(t, os.Mkdir(filepath.Join(root, orphanID), 0750))
+
+	// Unrelated directory
+	assert.NoError(t, os.Mkdir(filepath.Join(root, "not-a-volume"), 0750))
+
+	orphans, dangling, err := s.reconcile(root)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{filepath.Join(root, orphanID)}, orphans)
+	assert.Equal(t, []string{danglingID}, dangling)
+}
@@ -0,0 +1,250 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package iscsi
+
+import (
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/container-storage-interface/spec/lib/go/csi/v0"
+	"github.com/golang/glog"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/kubernetes-csi/drivers/pkg/csi-common"
+)
+
+const (
+	gib               = 1024 * 1024 * 1024
+	defaultVolumeSize = 1 * gib
+)
+
+type controllerServer struct {
+	*csicommon.DefaultControllerServer
+	volumeLocks *csicommon.VolumeLocks
+	target      *lioTarget
+}
+
+// volumeAttributes returns the attributes the node server needs to log in
+// to the target of vol, along with the parameters of the volume.
+func (cs *controllerServer) volumeAttributes(vol *lioVolume, parameters map[string]string) map[string]string {
+	attributes := map[string]string{}
+	for k, v := range parameters {
+		attributes[k] = v
+	}
+	attributes["targetPortal"] = cs.target.portal
+	attributes["iqn"] = cs.target.iqn
+	attributes["lun"] = strconv.Itoa(vol.LUN)
+	attributes["portals"] = "[]"
+	return attributes
+}
+
+func (cs *controllerServer) CreateVolume(ctx context.Context, req *csi.CreateVolumeRequest) (*csi.CreateVolumeResponse, error) {
+	if err := cs.Driver.ValidateControllerServiceRequest(csi.ControllerServiceCapability_RPC_CREATE_DELETE_VOLUME); err != nil {
+		glog.V(3).Infof("invalid create volume req: %v", csicommon.StripSecrets(req))
+		return nil, err
+	}
+
+	// Check arguments
+	if len(req.GetName()) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "Name missing in request")
+	}
+	if req.GetVolumeCapabilities() == nil {
+		return nil, status.Error(codes.InvalidArgument, "Volume Capabilities missing in request")
+	}
+	for _, c := range req.GetVolumeCapabilities() {
+		if err := cs.Driver.ValidateVolumeCapability(c); err != nil {
+			return nil, err
+		}
+	}
+	// The name is used as the name of the LIO storage object
+	volumeID := req.GetName()
+	if !backstoreNameRe.MatchString(volumeID) {
+		return nil, status.Errorf(codes.InvalidArgument, "Name %q must only contain letters, digits, - and _", volumeID)
+	}
+
+	required := req.GetCapacityRange().GetRequiredBytes()
+	limit := req.GetCapacityRange().GetLimitBytes()
+	size := required
+	if size == 0 {
+		size = defaultVolumeSize
+		if limit > 0 && limit < size {
+			size = limit
+		}
+	}
+	if limit > 0 && size > limit {
+		return nil, status.Errorf(codes.OutOfRange, "Required capacity %d exceeds limit %d", required, limit)
+	}
+
+	if err := cs.volumeLocks.Acquire(volumeID); err != nil {
+		return nil, err
+	}
+	defer cs.volumeLocks.Release(volumeID)
+
+	vol, err := cs.target.getVolume(volumeID)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	if vol != nil {
+		if vol.Size < required || (limit > 0 && vol.Size > limit) {
+			return nil, status.Errorf(codes.AlreadyExists, "Volume with the same name: %s but with different size already exist", volumeID)
+		}
+	} else {
+		glog.V(4).Infof("creating volume %s of %d bytes", volumeID, size)
+		vol, err = cs.target.createVolume(volumeID, size)
+		if err != nil {
+			glog.V(3).Infof("failed to create volume %s: %v", volumeID, err)
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+	}
+
+	return &csi.CreateVolumeResponse{
+		Volume: &csi.Volume{
+			Id:            volumeID,
+			CapacityBytes: vol.Size,
+			Attributes:    cs.volumeAttributes(vol, req.GetParameters()),
+		},
+	}, nil
+}
+
+func (cs *controllerServer) DeleteVolume(ctx context.Context, req *csi.DeleteVolumeRequest) (*csi.DeleteVolumeResponse, error) {
+	if err := cs.Driver.ValidateControllerServiceRequest(csi.ControllerServiceCapability_RPC_CREATE_DELETE_VOLUME); err != nil {
+		glog.V(3).Infof("invalid delete volume req: %v", csicommon.StripSecrets(req))
+		return nil, err
+	}
+
+	// Check arguments
+	if len(req.GetVolumeId()) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "Volume ID missing in request")
+	}
+	volumeID := req.GetVolumeId()
+	if err := cs.volumeLocks.Acquire(volumeID); err != nil {
+		return nil, err
+	}
+	defer cs.volumeLocks.Release(volumeID)
+
+	glog.V(4).Infof("deleting volume %s", volumeID)
+	if err := cs.target.deleteVolume(volumeID); err != nil {
+		glog.V(3).Infof("failed to delete volume %s: %v", volumeID, err)
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	return &csi.DeleteVolumeResponse{}, nil
+}
+
+// isInitiatorName reports whether nodeID is an iSCSI name, the node IDs
+// are the initiator names of the nodes.
+func isInitiatorName(nodeID string) bool {
+	for _, prefix := range []string{"iqn.", "eui.", "naa."} {
+		if strings.HasPrefix(nodeID, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func (cs *controllerServer) ControllerPublishVolume(ctx context.Context, req *csi.ControllerPublishVolumeRequest) (*csi.ControllerPublishVolumeResponse, error) {
+	if err := cs.Driver.ValidateControllerServiceRequest(csi.ControllerServiceCapability_RPC_PUBLISH_UNPUBLISH_VOLUME); err != nil {
+		return nil, err
+	}
+
+	// Check arguments
+	if len(req.GetVolumeId()) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "Volume ID missing in request")
+	}
+	if len(req.GetNodeId()) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "Node ID missing in request")
+	}
+	if req.GetVolumeCapability() == nil {
+		return nil, status.Error(codes.InvalidArgument, "Volume capability missing in request")
+	}
+	if err := cs.Driver.ValidateVolumeCapability(req.GetVolumeCapability()); err != nil {
+		return nil, err
+	}
+	if !isInitiatorName(req.GetNodeId()) {
+		return nil, status.Errorf(codes.NotFound, "Node ID %s is not an initiator name", req.GetNodeId())
+	}
+
+	volumeID := req.GetVolumeId()
+	if err := cs.volumeLocks.Acquire(volumeID); err != nil {
+		return nil, err
+	}
+	defer cs.volumeLocks.Release(volumeID)
+
+	glog.V(4).Infof("mapping volume %s to %s", volumeID, req.GetNodeId())
+	if err := cs.target.mapVolume(volumeID, req.GetNodeId(), req.GetReadonly()); err != nil {
+		if os.IsNotExist(err) {
+			return nil, status.Errorf(codes.NotFound, "Volume %s not found", volumeID)
+		}
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	return &csi.ControllerPublishVolumeResponse{}, nil
+}
+
+func (cs *controllerServer) ControllerUnpublishVolume(ctx context.Context, req *csi.ControllerUnpublishVolumeRequest) (*csi.ControllerUnpublishVolumeResponse, error) {
+	if err := cs.Driver.ValidateControllerServiceRequest(csi.ControllerServiceCapability_RPC_PUBLISH_UNPUBLISH_VOLUME); err != nil {
+		return nil, err
+	}
+
+	// Check arguments
+	if len(req.GetVolumeId()) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "Volume ID missing in request")
+	}
+	if len(req.GetNodeId()) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "Node ID missing in request")
+	}
+
+	volumeID := req.GetVolumeId()
+	if err := cs.volumeLocks.Acquire(volumeID); err != nil {
+		return nil, err
+	}
+	defer cs.volumeLocks.Release(volumeID)
+
+	glog.V(4).Infof("unmapping volume %s from %s", volumeID, req.GetNodeId())
+	if err := cs.target.unmapVolume(volumeID, req.GetNodeId()); err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	return &csi.ControllerUnpublishVolumeResponse{}, nil
+}
+
+func (cs *controllerServer) ValidateVolumeCapabilities(ctx context.Context, req *csi.ValidateVolumeCapabilitiesRequest) (*csi.ValidateVolumeCapabilitiesResponse, error) {
+	// Check arguments
+	if len(req.GetVolumeId()) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "Volume ID missing in request")
+	}
+	if req.GetVolumeCapabilities() == nil {
+		return nil, status.Error(codes.InvalidArgument, "Volume Capabilities missing in request")
+	}
+
+	vol, err := cs.target.getVolume(req.GetVolumeId())
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	if vol == nil {
+		return nil, status.Errorf(codes.NotFound, "Volume %s not found", req.GetVolumeId())
+	}
+
+	for _, c := range req.GetVolumeCapabilities() {
+		if err := cs.Driver.ValidateVolumeCapability(c); err != nil {
+			return &csi.ValidateVolumeCapabilitiesResponse{Supported: false, Message: status.Convert(err).Message()}, nil
+		}
+	}
+	return &csi.ValidateVolumeCapabilitiesResponse{Supported: true, Message: ""}, nil
+}
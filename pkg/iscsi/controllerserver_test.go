@@ -0,0 +1,246 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package iscsi
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi/v0"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/kubernetes/pkg/util/mount"
+
+	"github.com/kubernetes-csi/drivers/pkg/csi-common"
+	"github.com/kubernetes-csi/drivers/pkg/csi-common/csitest"
+)
+
+const (
+	testTargetIQN = "iqn.2017-01.io.k8s:target"
+	testInitiator = "iqn.2017-01.io.k8s:node1"
+)
+
+// fakeTargetcli records the targetcli commands and numbers the LUNs it
+// creates.
+type fakeTargetcli struct {
+	commands []string
+	luns     int
+}
+
+func (f *fakeTargetcli) exec() mount.Exec {
+	return mount.NewFakeExec(func(cmd string, args ...string) ([]byte, error) {
+		command := strings.Join(append([]string{cmd}, args...), " ")
+		f.commands = append(f.commands, command)
+		if len(args) > 1 && strings.HasSuffix(args[0], "/luns") && args[1] == "create" {
+			f.luns++
+			return []byte(fmt.Sprintf("Created LUN %d.", f.luns-1)), nil
+		}
+		return nil, nil
+	})
+}
+
+func newTestDriver(t *testing.T) (*driver, *controllerServer, *fakeTargetcli, func()) {
+	dir, err := ioutil.TempDir("", "iscsi-target")
+	assert.NoError(t, err)
+
+	d := NewDriver(testInitiator, "", filepath.Join(dir, "state"), &ControllerConfig{
+		TargetIQN:    testTargetIQN,
+		TargetPortal: "10.0.0.1:3260",
+		BackstoreDir: filepath.Join(dir, "backstores"),
+	})
+	fake := &fakeTargetcli{}
+	cs := NewControllerServer(d, fake.exec())
+	assert.NoError(t, cs.target.ensureTarget())
+	fake.commands = nil
+	return d, cs, fake, func() { os.RemoveAll(dir) }
+}
+
+func mountCapability() *csi.VolumeCapability {
+	return &csi.VolumeCapability{
+		AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{}},
+		AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER},
+	}
+}
+
+func TestControllerConformance(t *testing.T) {
+	d, cs, _, cleanup := newTestDriver(t)
+	defer cleanup()
+
+	csitest.Run(t, csitest.Config{
+		Identity:   csicommon.NewDefaultIdentityServer(d.csiDriver),
+		Controller: cs,
+		// The node ID is the initiator name the volumes are mapped to
+		Node: NewNodeServer(d),
+		// Probing runs the health checks of iscsiadm and targetcli, staging
+		// volumes logs in to the target
+		Skip: []string{"Identity/Probe", "Node/PublishUnpublish"},
+	})
+}
+
+func TestCreateVolume(t *testing.T) {
+	_, cs, fake, cleanup := newTestDriver(t)
+	defer cleanup()
+
+	req := &csi.CreateVolumeRequest{
+		Name:               "vol1",
+		CapacityRange:      &csi.CapacityRange{RequiredBytes: 1024 * 1024},
+		VolumeCapabilities: []*csi.VolumeCapability{mountCapability()},
+		Parameters:         map[string]string{"fsType": "ext4"},
+	}
+	resp, err := cs.CreateVolume(context.Background(), req)
+	assert.NoError(t, err)
+	assert.Equal(t, "vol1", resp.GetVolume().GetId())
+	assert.Equal(t, int64(1024*1024), resp.GetVolume().GetCapacityBytes())
+	assert.Equal(t, map[string]string{
+		"fsType":       "ext4",
+		"targetPortal": "10.0.0.1:3260",
+		"iqn":          testTargetIQN,
+		"lun":          "0",
+		"portals":      "[]",
+	}, resp.GetVolume().GetAttributes())
+
+	image := cs.target.imageFile("vol1")
+	fi, err := os.Stat(image)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1024*1024), fi.Size())
+	assert.Equal(t, []string{
+		"targetcli /backstores/fileio create name=vol1 file_or_dev=" + image,
+		"targetcli /iscsi/" + testTargetIQN + "/tpg1/luns create /backstores/fileio/vol1",
+		"targetcli saveconfig",
+	}, fake.commands)
+
+	// Creating the volume again returns it unchanged
+	fake.commands = nil
+	again, err := cs.CreateVolume(context.Background(), req)
+	assert.NoError(t, err)
+	assert.Equal(t, resp, again)
+	assert.Empty(t, fake.commands)
+
+	// but not with a larger size
+	req.CapacityRange.RequiredBytes = 2 * 1024 * 1024
+	_, err = cs.CreateVolume(context.Background(), req)
+	assert.Equal(t, codes.AlreadyExists, status.Code(err))
+
+	// Names which are not valid backstore names are rejected
+	req.Name = "pool/vol2"
+	_, err = cs.CreateVolume(context.Background(), req)
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+func TestControllerPublishVolume(t *testing.T) {
+	_, cs, fake, cleanup := newTestDriver(t)
+	defer cleanup()
+
+	for _, name := range []string{"vol1", "vol2"} {
+		_, err := cs.CreateVolume(context.Background(), &csi.CreateVolumeRequest{
+			Name:               name,
+			VolumeCapabilities: []*csi.VolumeCapability{mountCapability()},
+		})
+		assert.NoError(t, err)
+	}
+	tpg := "targetcli /iscsi/" + testTargetIQN + "/tpg1"
+
+	publish := func(volumeID, nodeID string, readOnly bool) error {
+		_, err := cs.ControllerPublishVolume(context.Background(), &csi.ControllerPublishVolumeRequest{
+			VolumeId:         volumeID,
+			NodeId:           nodeID,
+			VolumeCapability: mountCapability(),
+			Readonly:         readOnly,
+		})
+		return err
+	}
+	unpublish := func(volumeID, nodeID string) error {
+		_, err := cs.ControllerUnpublishVolume(context.Background(), &csi.ControllerUnpublishVolumeRequest{
+			VolumeId: volumeID,
+			NodeId:   nodeID,
+		})
+		return err
+	}
+
+	fake.commands = nil
+	assert.NoError(t, publish("vol1", testInitiator, false))
+	assert.NoError(t, publish("vol2", testInitiator, true))
+	assert.Equal(t, []string{
+		tpg + "/acls create wwn=" + testInitiator + " add_mapped_luns=false",
+		tpg + "/acls/" + testInitiator + " create mapped_lun=0 tpg_lun_or_backstore=lun0 write_protect=false",
+		"targetcli saveconfig",
+		tpg + "/acls create wwn=" + testInitiator + " add_mapped_luns=false",
+		tpg + "/acls/" + testInitiator + " create mapped_lun=1 tpg_lun_or_backstore=lun1 write_protect=true",
+		"targetcli saveconfig",
+	}, fake.commands)
+
+	// Publishing again is a no-op
+	fake.commands = nil
+	assert.NoError(t, publish("vol1", testInitiator, false))
+	assert.Empty(t, fake.commands)
+
+	assert.Equal(t, codes.NotFound, status.Code(publish("vol3", testInitiator, false)))
+	assert.Equal(t, codes.NotFound, status.Code(publish("vol1", "node1", false)))
+
+	// The ACL is kept while vol2 is mapped to the initiator
+	assert.NoError(t, unpublish("vol1", testInitiator))
+	assert.Equal(t, []string{
+		tpg + "/acls/" + testInitiator + " delete mapped_lun=0",
+		"targetcli saveconfig",
+	}, fake.commands)
+
+	fake.commands = nil
+	assert.NoError(t, unpublish("vol2", testInitiator))
+	assert.Equal(t, []string{
+		tpg + "/acls/" + testInitiator + " delete mapped_lun=1",
+		tpg + "/acls delete wwn=" + testInitiator,
+		"targetcli saveconfig",
+	}, fake.commands)
+
+	// Unpublishing again is a no-op
+	fake.commands = nil
+	assert.NoError(t, unpublish("vol2", testInitiator))
+	assert.Empty(t, fake.commands)
+}
+
+func TestDeleteVolume(t *testing.T) {
+	_, cs, fake, cleanup := newTestDriver(t)
+	defer cleanup()
+
+	_, err := cs.CreateVolume(context.Background(), &csi.CreateVolumeRequest{
+		Name:               "vol1",
+		VolumeCapabilities: []*csi.VolumeCapability{mountCapability()},
+	})
+	assert.NoError(t, err)
+
+	fake.commands = nil
+	_, err = cs.DeleteVolume(context.Background(), &csi.DeleteVolumeRequest{VolumeId: "vol1"})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{
+		"targetcli /backstores/fileio delete name=vol1",
+		"targetcli saveconfig",
+	}, fake.commands)
+	_, err = os.Stat(cs.target.imageFile("vol1"))
+	assert.True(t, os.IsNotExist(err))
+
+	// Deleting a missing volume succeeds
+	fake.commands = nil
+	_, err = cs.DeleteVolume(context.Background(), &csi.DeleteVolumeRequest{VolumeId: "vol1"})
+	assert.NoError(t, err)
+	assert.Empty(t, fake.commands)
+}
@@ -17,8 +17,13 @@ limitations under the License.
 package iscsi
 
 import (
+	"os"
+	"time"
+
 	"github.com/container-storage-interface/spec/lib/go/csi/v0"
 	"github.com/golang/glog"
+	"k8s.io/kubernetes/pkg/util/mount"
+	"k8s.io/kubernetes/pkg/volume/util"
 
 	"github.com/kubernetes-csi/drivers/pkg/csi-common"
 )
@@ -26,6 +31,14 @@ import (
 type driver struct {
 	csiDriver *csicommon.CSIDriver
 	endpoint  string
+	stateDir  string
+
+	controller *ControllerConfig
+
+	// Period of the reconciliation of the leaked sessions, 0 to only
+	// reconcile on startup
+	reconcilePeriod time.Duration
+	reconcileDryRun bool
 
 	ids *csicommon.DefaultIdentityServer
 	ns  *nodeServer
@@ -42,27 +55,97 @@ var (
 	version = "0.2.0"
 )
 
-func NewDriver(nodeID, endpoint string) *driver {
+// ControllerConfig describes the LIO target the controller server
+// provisions volumes in, the target runs on the host of the controller.
+type ControllerConfig struct {
+	// Name of the target, created if needed
+	TargetIQN string
+	// Portal the nodes log in to the target through
+	TargetPortal string
+	// Directory holding the backing files of the volumes
+	BackstoreDir string
+}
+
+// NewDriver returns a node-only driver unless controller is set.
+func NewDriver(nodeID, endpoint, stateDir string, controller *ControllerConfig) *driver {
 	glog.Infof("Driver: %v version: %v", driverName, version)
 
 	d := &driver{}
 
 	d.endpoint = endpoint
+	d.stateDir = stateDir
+	d.controller = controller
 
 	csiDriver := csicommon.NewCSIDriver(driverName, version, nodeID)
 	csiDriver.AddVolumeCapabilityAccessModes([]csi.VolumeCapability_AccessMode_Mode{csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER})
+	csiDriver.EnableBlockVolumes()
+	csiDriver.AddNodeServiceCapabilities([]csi.NodeServiceCapability_RPC_Type{csi.NodeServiceCapability_RPC_STAGE_UNSTAGE_VOLUME})
+	csiDriver.AddHealthCheck("iscsiadm", csicommon.BinaryHealthCheck("iscsiadm"))
+	if controller != nil {
+		csiDriver.AddControllerServiceCapabilities([]csi.ControllerServiceCapability_RPC_Type{
+			csi.ControllerServiceCapability_RPC_CREATE_DELETE_VOLUME,
+			csi.ControllerServiceCapability_RPC_PUBLISH_UNPUBLISH_VOLUME,
+		})
+		csiDriver.AddHealthCheck("targetcli", csicommon.BinaryHealthCheck("targetcli"))
+	}
+	// The secret attribute carried the CHAP credentials of older volumes,
+	// it is rejected but must not be logged either.
+	csicommon.AddSensitiveAttributeKeys("secret")
 
 	d.csiDriver = csiDriver
 
 	return d
 }
 
+// ConfigureReconciler sets how often the leaked sessions and ifaces are
+// cleaned up, and whether they are only reported.
+func (d *driver) ConfigureReconciler(period time.Duration, dryRun bool) {
+	d.reconcilePeriod = period
+	d.reconcileDryRun = dryRun
+}
+
+func NewControllerServer(d *driver, exec mount.Exec) *controllerServer {
+	return &controllerServer{
+		DefaultControllerServer: csicommon.NewDefaultControllerServer(d.csiDriver),
+		volumeLocks:             csicommon.NewVolumeLocks(),
+		target:                  newLIOTarget(d.controller.TargetIQN, d.controller.TargetPortal, d.controller.BackstoreDir, exec),
+	}
+}
+
 func NewNodeServer(d *driver) *nodeServer {
 	return &nodeServer{
 		DefaultNodeServer: csicommon.NewDefaultNodeServer(d.csiDriver),
+		volumeLocks:       csicommon.NewVolumeLocks(),
+		mounter:           csicommon.NewMounter(),
+		exec:              mount.NewOsExec(),
+		deviceUtil:        util.NewDeviceHandler(util.NewIOHandler()),
+		stateDir:          d.stateDir,
 	}
 }
 
-func (d *driver) Run() {
-	csicommon.RunNodePublishServer(d.endpoint, d.csiDriver, NewNodeServer(d))
+func (d *driver) Run() error {
+	ns := NewNodeServer(d)
+
+	// Log out of the volumes unstaged while the driver was not running,
+	// then periodically skipping the volumes being staged or unstaged
+	r := NewReconciler(d.stateDir, ns.volumeLocks, d.reconcileDryRun)
+	r.Reconcile()
+	if d.reconcilePeriod > 0 {
+		stop := make(chan struct{})
+		defer close(stop)
+		go r.Run(d.reconcilePeriod, stop)
+	}
+
+	if d.controller == nil {
+		return csicommon.RunNodePublishServer(d.endpoint, d.csiDriver, ns)
+	}
+
+	cs := NewControllerServer(d, mount.NewOsExec())
+	if err := os.MkdirAll(d.controller.BackstoreDir, 0750); err != nil {
+		return err
+	}
+	if err := cs.target.ensureTarget(); err != nil {
+		return err
+	}
+	return csicommon.RunControllerandNodePublishServer(d.endpoint, d.csiDriver, cs, ns)
 }
@@ -19,25 +19,81 @@ package iscsi
 import (
 	"encoding/json"
 	"fmt"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/container-storage-interface/spec/lib/go/csi/v0"
 	"k8s.io/kubernetes/pkg/util/mount"
 	"k8s.io/kubernetes/pkg/volume/util"
+
+	"github.com/kubernetes-csi/drivers/pkg/csi-common"
+)
+
+const (
+	// Discover the targets of the portals with SendTargets
+	discoverySendTargets = "sendtargets"
+	// Create the node records of the portals directly
+	discoveryStatic = "static"
+	// Query the iSNS server of the isnsServer attribute
+	discoveryISNS = "isns"
+)
+
+const (
+	// How long to wait for the device of a portal to appear after logging
+	// in, unless the deviceTimeout attribute says otherwise
+	defaultDeviceTimeout = 10 * time.Second
+	maxDeviceTimeout     = 600
 )
 
-func getISCSIInfo(req *csi.NodePublishVolumeRequest) (*iscsiDisk, error) {
-	volName := req.GetVolumeId()
-	tp := req.GetVolumeAttributes()["targetPortal"]
-	iqn := req.GetVolumeAttributes()["iqn"]
-	lun := req.GetVolumeAttributes()["lun"]
+// sessionAttributes lists the volume attributes tuning the sessions, the
+// node settings they set and the range of their values.
+var sessionAttributes = []struct {
+	attribute string
+	key       string
+	min, max  int
+}{
+	{"replacementTimeout", "node.session.timeo.replacement_timeout", 0, 86400},
+	{"loginTimeout", "node.conn[0].timeo.login_timeout", 1, 3600},
+	{"queueDepth", "node.session.queue_depth", 1, 1024},
+}
+
+// nodeSetting is a setting of a node record, see iscsiadm -o update.
+type nodeSetting struct {
+	key   string
+	value string
+}
+
+// getSessionSettings validates the attributes tuning the sessions and
+// returns the node settings to apply before logging in.
+func getSessionSettings(attributes map[string]string) ([]nodeSetting, error) {
+	var settings []nodeSetting
+	for _, a := range sessionAttributes {
+		v, ok := attributes[a.attribute]
+		if !ok {
+			continue
+		}
+		n, err := strconv.Atoi(v)
+		if err != nil || n < a.min || n > a.max {
+			return nil, fmt.Errorf("invalid %s %q, expected a number between %d and %d", a.attribute, v, a.min, a.max)
+		}
+		settings = append(settings, nodeSetting{key: a.key, value: strconv.Itoa(n)})
+	}
+	return settings, nil
+}
+
+func getISCSIInfo(volName string, attributes, secrets map[string]string) (*iscsiDisk, error) {
+	tp := attributes["targetPortal"]
+	iqn := attributes["iqn"]
+	lun := attributes["lun"]
 	if tp == "" || iqn == "" || lun == "" {
 		return nil, fmt.Errorf("iSCSI target information is missing")
 	}
 
-	portalList := req.GetVolumeAttributes()["portals"]
-	secretParams := req.GetVolumeAttributes()["secret"]
-	secret := parseSecret(secretParams)
+	portalList := attributes["portals"]
+	if _, ok := attributes["secret"]; ok {
+		return nil, fmt.Errorf("the secret attribute is not supported, CHAP credentials are passed as node stage secrets")
+	}
 
 	portal := portalMounter(tp)
 	var bkportal []string
@@ -51,55 +107,107 @@ func getISCSIInfo(req *csi.NodePublishVolumeRequest) (*iscsiDisk, error) {
 	for _, portal := range portals {
 		bkportal = append(bkportal, portalMounter(string(portal)))
 	}
+	// One path is expected per portal, the target portal may be listed again
+	bkportal = removeDuplicate(bkportal)
 
-	iface := req.GetVolumeAttributes()["iscsiInterface"]
-	initiatorName := req.GetVolumeAttributes()["initiatorName"]
+	iface := attributes["iscsiInterface"]
+	initiatorName := attributes["initiatorName"]
 	chapDiscovery := false
-	if req.GetVolumeAttributes()["discoveryCHAPAuth"] == "true" {
+	if attributes["discoveryCHAPAuth"] == "true" {
 		chapDiscovery = true
 	}
 
 	chapSession := false
-	if req.GetVolumeAttributes()["sessionCHAPAuth"] == "true" {
+	if attributes["sessionCHAPAuth"] == "true" {
 		chapSession = true
 	}
 
+	if err := validateCHAPSecrets(secrets, chapDiscovery, chapSession); err != nil {
+		return nil, err
+	}
+
+	discovery := attributes["discovery"]
+	if discovery == "" {
+		discovery = discoverySendTargets
+	}
+	isnsServer := attributes["isnsServer"]
+	switch discovery {
+	case discoverySendTargets, discoveryStatic:
+	case discoveryISNS:
+		if isnsServer == "" {
+			return nil, fmt.Errorf("isns discovery requires the isnsServer attribute")
+		}
+		isnsServer = isnsPortal(isnsServer)
+	default:
+		return nil, fmt.Errorf("unknown discovery %q, expected %s, %s or %s", discovery, discoverySendTargets, discoveryStatic, discoveryISNS)
+	}
+	// iscsiadm only authenticates SendTargets discovery
+	if chapDiscovery && discovery != discoverySendTargets {
+		return nil, fmt.Errorf("discoveryCHAPAuth is only supported with %s discovery", discoverySendTargets)
+	}
+
+	minHealthyPaths := 1
+	if v, ok := attributes["minHealthyPaths"]; ok {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > len(bkportal) {
+			return nil, fmt.Errorf("invalid minHealthyPaths %q, expected a number between 1 and the number of portals %d", v, len(bkportal))
+		}
+		minHealthyPaths = n
+	}
+
+	sessionSettings, err := getSessionSettings(attributes)
+	if err != nil {
+		return nil, err
+	}
+	deviceTimeout := defaultDeviceTimeout
+	if v, ok := attributes["deviceTimeout"]; ok {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxDeviceTimeout {
+			return nil, fmt.Errorf("invalid deviceTimeout %q, expected a number of seconds between 1 and %d", v, maxDeviceTimeout)
+		}
+		deviceTimeout = time.Duration(n) * time.Second
+	}
+
 	return &iscsiDisk{
-		VolName:        volName,
-		Portals:        bkportal,
-		Iqn:            iqn,
-		lun:            lun,
-		Iface:          iface,
-		chap_discovery: chapDiscovery,
-		chap_session:   chapSession,
-		secret:         secret,
-		InitiatorName:  initiatorName}, nil
+		VolName:         volName,
+		Portals:         bkportal,
+		Iqn:             iqn,
+		lun:             lun,
+		Iface:           iface,
+		chap_discovery:  chapDiscovery,
+		chap_session:    chapSession,
+		secret:          secrets,
+		discovery:       discovery,
+		isnsServer:      isnsServer,
+		minHealthyPaths: minHealthyPaths,
+		sessionSettings: sessionSettings,
+		deviceTimeout:   deviceTimeout,
+		InitiatorName:   initiatorName}, nil
 }
 
-func getISCSIDiskMounter(iscsiInfo *iscsiDisk, req *csi.NodePublishVolumeRequest) *iscsiDiskMounter {
-	readOnly := req.GetReadonly()
+func getISCSIDiskMounter(iscsiInfo *iscsiDisk, req *csi.NodeStageVolumeRequest, mounter *csicommon.Mounter, exec mount.Exec, deviceUtil util.DeviceUtil) *iscsiDiskMounter {
+	iscsiInfo.StagingPath = req.GetStagingTargetPath()
 	fsType := req.GetVolumeCapability().GetMount().GetFsType()
 	mountOptions := req.GetVolumeCapability().GetMount().GetMountFlags()
 
 	return &iscsiDiskMounter{
 		iscsiDisk:    iscsiInfo,
 		fsType:       fsType,
-		readOnly:     readOnly,
+		block:        req.GetVolumeCapability().GetBlock() != nil,
 		mountOptions: mountOptions,
-		mounter:      &mount.SafeFormatAndMount{Interface: mount.New(""), Exec: mount.NewOsExec()},
-		exec:         mount.NewOsExec(),
-		targetPath:   req.GetTargetPath(),
-		deviceUtil:   util.NewDeviceHandler(util.NewIOHandler()),
+		mounter:      mounter,
+		exec:         exec,
+		deviceUtil:   deviceUtil,
 	}
 }
 
-func getISCSIDiskUnmounter(req *csi.NodeUnpublishVolumeRequest) *iscsiDiskUnmounter {
+func getISCSIDiskUnmounter(req *csi.NodeUnstageVolumeRequest, mounter mount.Interface, exec mount.Exec) *iscsiDiskUnmounter {
 	return &iscsiDiskUnmounter{
 		iscsiDisk: &iscsiDisk{
 			VolName: req.GetVolumeId(),
 		},
-		mounter: mount.New(""),
-		exec:    mount.NewOsExec(),
+		mounter: mounter,
+		exec:    exec,
 	}
 }
 
@@ -110,12 +218,38 @@ func portalMounter(portal string) string {
 	return portal
 }
 
-func parseSecret(secretParams string) map[string]string {
-	var secret map[string]string
-	if err := json.Unmarshal([]byte(secretParams), &secret); err != nil {
-		return nil
+func isnsPortal(server string) string {
+	if !strings.Contains(server, ":") {
+		server = server + ":3205"
+	}
+	return server
+}
+
+// validateCHAPSecrets checks that the secrets only hold the CHAP keys of
+// chap_st and chap_sess, and that they hold the credentials of the CHAP
+// authentications requested.
+func validateCHAPSecrets(secrets map[string]string, chapDiscovery, chapSession bool) error {
+	for k := range secrets {
+		if !hasKey(chap_st, k) && !hasKey(chap_sess, k) {
+			return fmt.Errorf("unknown CHAP secret key %q", k)
+		}
+	}
+	if chapDiscovery && (secrets[chap_st[0]] == "" || secrets[chap_st[1]] == "") {
+		return fmt.Errorf("discovery CHAP authentication requested but %s and %s are missing in the node stage secrets", chap_st[0], chap_st[1])
+	}
+	if chapSession && (secrets[chap_sess[0]] == "" || secrets[chap_sess[1]] == "") {
+		return fmt.Errorf("session CHAP authentication requested but %s and %s are missing in the node stage secrets", chap_sess[0], chap_sess[1])
+	}
+	return nil
+}
+
+func hasKey(keys []string, key string) bool {
+	for _, k := range keys {
+		if k == key {
+			return true
+		}
 	}
-	return secret
+	return false
 }
 
 type iscsiDisk struct {
@@ -126,19 +260,35 @@ type iscsiDisk struct {
 	chap_discovery bool
 	chap_session   bool
 	secret         map[string]string
-	InitiatorName  string
-	VolName        string
+	// How the target is discovered, see the discovery* constants
+	discovery  string
+	isnsServer string
+	// Number of healthy multipath paths required to stage the volume
+	minHealthyPaths int
+	InitiatorName   string
+	VolName         string
+	// Path the volume is staged on, recorded for the reconciler
+	StagingPath string
+	// /dev/mapper path of the multipath device of the volume, if any
+	MultipathDevice string
+	// SCSI devices of the paths of the volume, e.g. sdb
+	Devices []string
+	// Size of the device the file system was last grown to
+	DeviceSize int64
+	// Applied to the node records before logging in
+	sessionSettings []nodeSetting
+	// How long to wait for the device of a portal after logging in
+	deviceTimeout time.Duration
 }
 
 type iscsiDiskMounter struct {
 	*iscsiDisk
-	readOnly     bool
+	block        bool
 	fsType       string
 	mountOptions []string
-	mounter      *mount.SafeFormatAndMount
+	mounter      *csicommon.Mounter
 	exec         mount.Exec
 	deviceUtil   util.DeviceUtil
-	targetPath   string
 }
 
 type iscsiDiskUnmounter struct {
@@ -0,0 +1,232 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package iscsi
+
+import (
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/context"
+	"k8s.io/kubernetes/pkg/util/mount"
+)
+
+func fakeAttributes(extra map[string]string) map[string]string {
+	attributes := map[string]string{
+		"targetPortal": "10.0.0.1",
+		"iqn":          "iqn.2017-01.io.k8s:vol1",
+		"lun":          "0",
+		"portals":      `["10.0.0.2:3260"]`,
+	}
+	for k, v := range extra {
+		attributes[k] = v
+	}
+	return attributes
+}
+
+func TestGetISCSIInfo(t *testing.T) {
+	disk, err := getISCSIInfo("vol1", fakeAttributes(nil), nil)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"10.0.0.1:3260", "10.0.0.2:3260"}, disk.Portals)
+	assert.False(t, disk.chap_discovery)
+	assert.False(t, disk.chap_session)
+
+	// Default minimum number of healthy paths
+	assert.Equal(t, 1, disk.minHealthyPaths)
+	disk, err = getISCSIInfo("vol1", fakeAttributes(map[string]string{"minHealthyPaths": "2"}), nil)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, disk.minHealthyPaths)
+	// More paths than portals
+	_, err = getISCSIInfo("vol1", fakeAttributes(map[string]string{"minHealthyPaths": "3"}), nil)
+	assert.Error(t, err)
+
+	// The target portal listed again in the portals is only one path
+	disk, err = getISCSIInfo("vol1", fakeAttributes(map[string]string{"portals": `["10.0.0.1:3260", "10.0.0.2", "10.0.0.2:3260"]`}), nil)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"10.0.0.1:3260", "10.0.0.2:3260"}, disk.Portals)
+	_, err = getISCSIInfo("vol1", fakeAttributes(map[string]string{"portals": `["10.0.0.1:3260"]`, "minHealthyPaths": "2"}), nil)
+	assert.Error(t, err)
+
+	// Target information missing
+	_, err = getISCSIInfo("vol1", map[string]string{"iqn": "iqn.2017-01.io.k8s:vol1"}, nil)
+	assert.Error(t, err)
+}
+
+func TestGetISCSIInfoCHAP(t *testing.T) {
+	secrets := map[string]string{
+		"node.session.auth.username": "user",
+		"node.session.auth.password": "password",
+	}
+	disk, err := getISCSIInfo("vol1", fakeAttributes(map[string]string{"sessionCHAPAuth": "true"}), secrets)
+	assert.NoError(t, err)
+	assert.True(t, disk.chap_session)
+	assert.Equal(t, secrets, disk.secret)
+
+	// Session CHAP requested without credentials
+	_, err = getISCSIInfo("vol1", fakeAttributes(map[string]string{"sessionCHAPAuth": "true"}), nil)
+	assert.Error(t, err)
+
+	// Discovery CHAP requested with session credentials only
+	_, err = getISCSIInfo("vol1", fakeAttributes(map[string]string{"discoveryCHAPAuth": "true"}), secrets)
+	assert.Error(t, err)
+
+	// Unknown key
+	_, err = getISCSIInfo("vol1", fakeAttributes(nil), map[string]string{"username": "user"})
+	assert.Error(t, err)
+
+	// Credentials in the volume attributes
+	_, err = getISCSIInfo("vol1", fakeAttributes(map[string]string{"secret": `{"node.session.auth.username": "user"}`}), nil)
+	assert.Error(t, err)
+}
+
+func TestGetISCSIInfoDiscovery(t *testing.T) {
+	disk, err := getISCSIInfo("vol1", fakeAttributes(nil), nil)
+	assert.NoError(t, err)
+	assert.Equal(t, discoverySendTargets, disk.discovery)
+
+	disk, err = getISCSIInfo("vol1", fakeAttributes(map[string]string{"discovery": "isns", "isnsServer": "10.0.0.5"}), nil)
+	assert.NoError(t, err)
+	assert.Equal(t, discoveryISNS, disk.discovery)
+	assert.Equal(t, "10.0.0.5:3205", disk.isnsServer)
+
+	// iSNS server missing
+	_, err = getISCSIInfo("vol1", fakeAttributes(map[string]string{"discovery": "isns"}), nil)
+	assert.Error(t, err)
+
+	// Unknown discovery
+	_, err = getISCSIInfo("vol1", fakeAttributes(map[string]string{"discovery": "slp"}), nil)
+	assert.Error(t, err)
+
+	// Discovery CHAP is only supported by SendTargets
+	secrets := map[string]string{
+		"discovery.sendtargets.auth.username": "user",
+		"discovery.sendtargets.auth.password": "password",
+	}
+	_, err = getISCSIInfo("vol1", fakeAttributes(map[string]string{"discovery": "static", "discoveryCHAPAuth": "true"}), secrets)
+	assert.Error(t, err)
+}
+
+func TestDiscoverTarget(t *testing.T) {
+	tests := []struct {
+		attributes map[string]string
+		expected   []string
+	}{
+		{
+			attributes: nil,
+			expected: []string{
+				"iscsiadm -m discoverydb -t sendtargets -p 10.0.0.1:3260 -I default -o new",
+				"iscsiadm -m discoverydb -t sendtargets -p 10.0.0.1:3260 -I default --discover",
+			},
+		},
+		{
+			attributes: map[string]string{"discovery": "static"},
+			expected: []string{
+				"iscsiadm -m node -p 10.0.0.1:3260 -T iqn.2017-01.io.k8s:vol1 -I default -o new",
+			},
+		},
+		{
+			attributes: map[string]string{"discovery": "isns", "isnsServer": "10.0.0.5:3205"},
+			expected: []string{
+				"iscsiadm -m discoverydb -t isns -p 10.0.0.5:3205 -I default -o new",
+				"iscsiadm -m discoverydb -t isns -p 10.0.0.5:3205 -I default --discover",
+			},
+		},
+	}
+
+	for _, test := range tests {
+		disk, err := getISCSIInfo("vol1", fakeAttributes(test.attributes), nil)
+		assert.NoError(t, err)
+		disk.Iface = "default"
+
+		var calls []string
+		b := iscsiDiskMounter{
+			iscsiDisk: disk,
+			exec: mount.NewFakeExec(func(cmd string, args ...string) ([]byte, error) {
+				calls = append(calls, cmd+" "+strings.Join(args, " "))
+				return nil, nil
+			}),
+		}
+		assert.NoError(t, discoverTarget(b, "10.0.0.1:3260"))
+		assert.Equal(t, test.expected, calls)
+	}
+}
+
+func TestGetISCSIInfoSessionSettings(t *testing.T) {
+	disk, err := getISCSIInfo("vol1", fakeAttributes(nil), nil)
+	assert.NoError(t, err)
+	assert.Empty(t, disk.sessionSettings)
+	assert.Equal(t, defaultDeviceTimeout, disk.deviceTimeout)
+
+	disk, err = getISCSIInfo("vol1", fakeAttributes(map[string]string{
+		"queueDepth":         "64",
+		"replacementTimeout": "15",
+		"deviceTimeout":      "30",
+	}), nil)
+	assert.NoError(t, err)
+	assert.Equal(t, []nodeSetting{
+		{key: "node.session.timeo.replacement_timeout", value: "15"},
+		{key: "node.session.queue_depth", value: "64"},
+	}, disk.sessionSettings)
+	assert.Equal(t, 30*time.Second, disk.deviceTimeout)
+
+	for _, attributes := range []map[string]string{
+		{"queueDepth": "0"},
+		{"loginTimeout": "fast"},
+		{"replacementTimeout": "-1"},
+		{"deviceTimeout": "0"},
+	} {
+		_, err = getISCSIInfo("vol1", fakeAttributes(attributes), nil)
+		assert.Error(t, err, "attributes %v", attributes)
+	}
+
+	var calls []string
+	b := iscsiDiskMounter{
+		iscsiDisk: disk,
+		exec: mount.NewFakeExec(func(cmd string, args ...string) ([]byte, error) {
+			calls = append(calls, cmd+" "+strings.Join(args, " "))
+			return nil, nil
+		}),
+	}
+	b.Iface = "default"
+	assert.NoError(t, updateISCSISession(b, "10.0.0.1:3260"))
+	assert.Equal(t, []string{
+		"iscsiadm -m node -p 10.0.0.1:3260 -T iqn.2017-01.io.k8s:vol1 -I default -o update -n node.session.timeo.replacement_timeout -v 15",
+		"iscsiadm -m node -p 10.0.0.1:3260 -T iqn.2017-01.io.k8s:vol1 -I default -o update -n node.session.queue_depth -v 64",
+	}, calls)
+}
+
+func TestWaitForPathToExistDeadline(t *testing.T) {
+	stats := 0
+	notExist := func(string) (os.FileInfo, error) {
+		stats++
+		return nil, os.ErrNotExist
+	}
+	path := "/dev/disk/by-path/ip-10.0.0.1:3260-iscsi-iqn.2017-01.io.k8s:vol1-lun-0"
+
+	// A zero timeout checks once
+	assert.False(t, waitForPathToExistInternal(context.Background(), &path, 0, "tcp", notExist, nil))
+	assert.Equal(t, 1, stats)
+
+	// The deadline of the context is honoured over the timeout
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	assert.False(t, waitForPathToExistInternal(ctx, &path, time.Minute, "tcp", notExist, nil))
+	assert.True(t, time.Since(start) < devicePollInterval)
+}
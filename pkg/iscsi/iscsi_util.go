@@ -17,18 +17,19 @@ limitations under the License.
 package iscsi
 
 import (
-	"encoding/json"
 	"fmt"
 	"os"
-	"path"
 	"path/filepath"
 	"regexp"
 	"strings"
 	"time"
 
 	"github.com/golang/glog"
+	"golang.org/x/net/context"
 	"k8s.io/kubernetes/pkg/util/mount"
 	volumeutil "k8s.io/kubernetes/pkg/volume/util"
+
+	"github.com/kubernetes-csi/drivers/pkg/csi-common"
 )
 
 var (
@@ -45,10 +46,26 @@ var (
 	ifaceTransportNameRe = regexp.MustCompile(`iface.transport_name = (.*)\n`)
 )
 
+// Ifaces cloned by AttachDisk are named csi-iscsi:<target portal>:<volume
+// name>, the prefix tells them from the ifaces cloned by the in-tree plugin.
+const clonedIfacePrefix = "csi-iscsi:"
+
+func clonedIfaceName(portal, volName string) string {
+	return clonedIfacePrefix + portal + ":" + volName
+}
+
+func isClonedIface(iface string) bool {
+	return strings.HasPrefix(iface, clonedIfacePrefix)
+}
+
 func updateISCSIDiscoverydb(b iscsiDiskMounter, tp string) error {
 	if !b.chap_discovery {
 		return nil
 	}
+	// The discovery.sendtargets keys do not apply to the other discoveries
+	if b.discovery != discoverySendTargets {
+		return fmt.Errorf("iscsi: CHAP authentication is not supported with %s discovery", b.discovery)
+	}
 	out, err := b.exec.Run("iscsiadm", "-m", "discoverydb", "-t", "sendtargets", "-p", tp, "-I", b.Iface, "-o", "update", "-n", "discovery.sendtargets.auth.authmethod", "-v", "CHAP")
 	if err != nil {
 		return fmt.Errorf("iscsi: failed to update discoverydb with CHAP, output: %v", string(out))
@@ -59,7 +76,7 @@ func updateISCSIDiscoverydb(b iscsiDiskMounter, tp string) error {
 		if len(v) > 0 {
 			out, err := b.exec.Run("iscsiadm", "-m", "discoverydb", "-t", "sendtargets", "-p", tp, "-I", b.Iface, "-o", "update", "-n", k, "-v", v)
 			if err != nil {
-				return fmt.Errorf("iscsi: failed to update discoverydb key %q with value %q error: %v", k, v, string(out))
+				return fmt.Errorf("iscsi: failed to update discoverydb key %q error: %v", k, string(out))
 			}
 		}
 	}
@@ -81,29 +98,90 @@ func updateISCSINode(b iscsiDiskMounter, tp string) error {
 		if len(v) > 0 {
 			out, err := b.exec.Run("iscsiadm", "-m", "node", "-p", tp, "-T", b.Iqn, "-I", b.Iface, "-o", "update", "-n", k, "-v", v)
 			if err != nil {
-				return fmt.Errorf("iscsi: failed to update node session key %q with value %q error: %v", k, v, string(out))
+				return fmt.Errorf("iscsi: failed to update node session key %q error: %v", k, string(out))
 			}
 		}
 	}
 	return nil
 }
 
-// stat a path, if not exists, retry maxRetries times
+// updateISCSISession applies the session settings of the volume to the
+// node record of portal tp. They only apply to sessions logged in to
+// afterwards.
+func updateISCSISession(b iscsiDiskMounter, tp string) error {
+	for _, setting := range b.sessionSettings {
+		out, err := b.exec.Run("iscsiadm", "-m", "node", "-p", tp, "-T", b.Iqn, "-I", b.Iface, "-o", "update", "-n", setting.key, "-v", setting.value)
+		if err != nil {
+			return fmt.Errorf("iscsi: failed to update node key %q error: %v", setting.key, string(out))
+		}
+	}
+	return nil
+}
+
+// discoverTarget creates the node record of the target on portal tp
+// according to the discovery of the volume.
+func discoverTarget(b iscsiDiskMounter, tp string) error {
+	switch b.discovery {
+	case discoveryStatic:
+		out, err := b.exec.Run("iscsiadm", "-m", "node", "-p", tp, "-T", b.Iqn, "-I", b.Iface, "-o", "new")
+		if err != nil {
+			return fmt.Errorf("iscsi: failed to create node record for portal %s output: %s, err %v", tp, string(out), err)
+		}
+		return nil
+	case discoveryISNS:
+		return discover(b, "isns", b.isnsServer)
+	default:
+		return discover(b, "sendtargets", tp)
+	}
+}
+
+// discover builds the discoverydb record of portal and discovers the
+// targets through it.
+func discover(b iscsiDiskMounter, discoveryType, portal string) error {
+	b.exec.Run("iscsiadm", "-m", "discoverydb", "-t", discoveryType, "-p", portal, "-I", b.Iface, "-o", "new")
+	// update discoverydb with CHAP secret
+	if err := updateISCSIDiscoverydb(b, portal); err != nil {
+		return fmt.Errorf("iscsi: failed to update discoverydb to portal %s error: %v", portal, err)
+	}
+	out, err := b.exec.Run("iscsiadm", "-m", "discoverydb", "-t", discoveryType, "-p", portal, "-I", b.Iface, "--discover")
+	if err != nil {
+		// delete discoverydb record
+		b.exec.Run("iscsiadm", "-m", "discoverydb", "-t", discoveryType, "-p", portal, "-I", b.Iface, "-o", "delete")
+		return fmt.Errorf("iscsi: failed to discover targets through %s %s output: %s, err %v", discoveryType, portal, string(out), err)
+	}
+	return nil
+}
+
+// Interval between the checks for a device to appear
+const devicePollInterval = time.Second
+
+// stat a path, if not exists, retry every devicePollInterval until timeout
+// elapses or ctx is done, a zero timeout checks once.
 // when iscsi transports other than default are used,  use glob instead as pci id of device is unknown
 type StatFunc func(string) (os.FileInfo, error)
 type GlobFunc func(string) ([]string, error)
 
-func waitForPathToExist(devicePath *string, maxRetries int, deviceTransport string) bool {
+// statDevice, globDevice and resolveDevice look up the device paths, tests
+// override them.
+var (
+	statDevice    StatFunc = os.Stat
+	globDevice    GlobFunc = filepath.Glob
+	resolveDevice          = filepath.EvalSymlinks
+)
+
+func waitForPathToExist(ctx context.Context, devicePath *string, timeout time.Duration, deviceTransport string) bool {
 	// This makes unit testing a lot easier
-	return waitForPathToExistInternal(devicePath, maxRetries, deviceTransport, os.Stat, filepath.Glob)
+	return waitForPathToExistInternal(ctx, devicePath, timeout, deviceTransport, statDevice, globDevice)
 }
 
-func waitForPathToExistInternal(devicePath *string, maxRetries int, deviceTransport string, osStat StatFunc, filepathGlob GlobFunc) bool {
+func waitForPathToExistInternal(ctx context.Context, devicePath *string, timeout time.Duration, deviceTransport string, osStat StatFunc, filepathGlob GlobFunc) bool {
 	if devicePath == nil {
 		return false
 	}
 
-	for i := 0; i < maxRetries; i++ {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	for {
 		var err error
 		if deviceTransport == "tcp" {
 			_, err = osStat(*devicePath)
@@ -124,51 +202,43 @@ func waitForPathToExistInternal(devicePath *string, maxRetries int, deviceTransp
 		if !os.IsNotExist(err) {
 			return false
 		}
-		if i == maxRetries-1 {
-			break
+		select {
+		case <-ctx.Done():
+			return false
+		case <-time.After(devicePollInterval):
 		}
-		time.Sleep(time.Second)
 	}
-	return false
 }
 
-type ISCSIUtil struct{}
-
-func (util *ISCSIUtil) persistISCSI(conf iscsiDisk, mnt string) error {
-	file := path.Join(mnt, conf.VolName+".json")
-	fp, err := os.Create(file)
-	if err != nil {
-		return fmt.Errorf("iscsi: create %s err %s", file, err)
-	}
-	defer fp.Close()
-	encoder := json.NewEncoder(fp)
-	if err = encoder.Encode(conf); err != nil {
-		return fmt.Errorf("iscsi: encode err: %v.", err)
-	}
-	return nil
+type ISCSIUtil struct {
+	// Directory holding the connection records of the staged volumes
+	stateDir string
 }
 
-func (util *ISCSIUtil) loadISCSI(conf *iscsiDisk, mnt string) error {
-	// NOTE: The iscsi config json is not deleted after logging out from target portals.
-	file := path.Join(mnt, conf.VolName+".json")
-	fp, err := os.Open(file)
-	if err != nil {
-		return fmt.Errorf("iscsi: open %s err %s", file, err)
-	}
-	defer fp.Close()
-	decoder := json.NewDecoder(fp)
-	if err = decoder.Decode(conf); err != nil {
-		return fmt.Errorf("iscsi: decode err: %v.", err)
-	}
-	return nil
+// stagedDevicePath returns the file the device of a block volume is bind
+// mounted on in the staging path.
+func stagedDevicePath(stagingPath, volName string) string {
+	return filepath.Join(stagingPath, volName)
 }
 
-func (util *ISCSIUtil) AttachDisk(b iscsiDiskMounter) (string, error) {
+func (util *ISCSIUtil) AttachDisk(ctx context.Context, b iscsiDiskMounter) (string, error) {
 	var devicePath string
 	var devicePaths []string
 	var iscsiTransport string
 	var lastErr error
 
+	// Hold the session lock until the volume is recorded as using the
+	// sessions it logs in to.
+	sessionLock.Lock()
+	locked := true
+	unlock := func() {
+		if locked {
+			sessionLock.Unlock()
+			locked = false
+		}
+	}
+	defer unlock()
+
 	out, err := b.exec.Run("iscsiadm", "-m", "iface", "-I", b.Iface, "-o", "show")
 	if err != nil {
 		glog.Errorf("iscsi: could not read iface %s error: %s", b.Iface, string(out))
@@ -181,8 +251,7 @@ func (util *ISCSIUtil) AttachDisk(b iscsiDiskMounter) (string, error) {
 
 	// create new iface and copy parameters from pre-configured iface to the created iface
 	if b.InitiatorName != "" {
-		// new iface name is <target portal>:<volume name>
-		newIface := bkpPortal[0] + ":" + b.VolName
+		newIface := clonedIfaceName(bkpPortal[0], b.VolName)
 		err = cloneIface(b, newIface)
 		if err != nil {
 			glog.Errorf("iscsi: failed to clone iface: %s error: %v", b.Iface, err)
@@ -192,6 +261,28 @@ func (util *ISCSIUtil) AttachDisk(b iscsiDiskMounter) (string, error) {
 		b.Iface = newIface
 	}
 
+	// A volume staged again keeps the map, the devices and the size
+	// recorded for it, DetachDisk and the reconciler rely on them.
+	recorded := iscsiDisk{VolName: b.VolName}
+	if err := util.loadISCSI(&recorded); err == nil {
+		b.MultipathDevice, b.Devices, b.DeviceSize = recorded.MultipathDevice, recorded.Devices, recorded.DeviceSize
+	} else if !os.IsNotExist(err) {
+		glog.Errorf("iscsi: failed to read iscsi config with error: %v", err)
+		return "", err
+	}
+
+	// Record the volume before logging in, so that the reconciler logs out
+	// of the target if the driver stops before the volume is staged.
+	if err := util.persistISCSI(*(b.iscsiDisk)); err != nil {
+		glog.Errorf("iscsi: failed to save iscsi config with error: %v", err)
+		if b.InitiatorName != "" {
+			b.exec.Run("iscsiadm", "-m", "iface", "-I", b.Iface, "-o", "delete")
+		}
+		return "", err
+	}
+	unlock()
+
+	discovered := false
 	for _, tp := range bkpPortal {
 		// Rescan sessions to discover newly mapped LUNs. Do not specify the interface when rescanning
 		// to avoid establishing additional sessions to the same target.
@@ -210,25 +301,18 @@ func (util *ISCSIUtil) AttachDisk(b iscsiDiskMounter) (string, error) {
 			devicePath = strings.Join([]string{"/dev/disk/by-path/pci", "*", "ip", tp, "iscsi", b.Iqn, "lun", b.lun}, "-")
 		}
 
-		if exist := waitForPathToExist(&devicePath, 1, iscsiTransport); exist {
+		if exist := waitForPathToExist(ctx, &devicePath, 0, iscsiTransport); exist {
 			glog.V(4).Infof("iscsi: devicepath (%s) exists", devicePath)
 			devicePaths = append(devicePaths, devicePath)
 			continue
 		}
-		// build discoverydb and discover iscsi target
-		b.exec.Run("iscsiadm", "-m", "discoverydb", "-t", "sendtargets", "-p", tp, "-I", b.Iface, "-o", "new")
-		// update discoverydb with CHAP secret
-		err = updateISCSIDiscoverydb(b, tp)
-		if err != nil {
-			lastErr = fmt.Errorf("iscsi: failed to update discoverydb to portal %s error: %v", tp, err)
-			continue
-		}
-		out, err = b.exec.Run("iscsiadm", "-m", "discoverydb", "-t", "sendtargets", "-p", tp, "-I", b.Iface, "--discover")
-		if err != nil {
-			// delete discoverydb record
-			b.exec.Run("iscsiadm", "-m", "discoverydb", "-t", "sendtargets", "-p", tp, "-I", b.Iface, "-o", "delete")
-			lastErr = fmt.Errorf("iscsi: failed to sendtargets to portal %s output: %s, err %v", tp, string(out), err)
-			continue
+		// The iSNS server lists the targets of all the portals at once
+		if !discovered {
+			if err := discoverTarget(b, tp); err != nil {
+				lastErr = err
+				continue
+			}
+			discovered = b.discovery == discoveryISNS
 		}
 		err = updateISCSINode(b, tp)
 		if err != nil {
@@ -236,6 +320,10 @@ func (util *ISCSIUtil) AttachDisk(b iscsiDiskMounter) (string, error) {
 			lastErr = fmt.Errorf("iscsi: failed to update iscsi node to portal %s error: %v", tp, err)
 			continue
 		}
+		if err := updateISCSISession(b, tp); err != nil {
+			lastErr = fmt.Errorf("iscsi: failed to update iscsi node to portal %s error: %v", tp, err)
+			continue
+		}
 		// login to iscsi target
 		out, err = b.exec.Run("iscsiadm", "-m", "node", "-p", tp, "-T", b.Iqn, "-I", b.Iface, "--login")
 		if err != nil {
@@ -244,10 +332,14 @@ func (util *ISCSIUtil) AttachDisk(b iscsiDiskMounter) (string, error) {
 			lastErr = fmt.Errorf("iscsi: failed to attach disk: Error: %s (%v)", string(out), err)
 			continue
 		}
-		if exist := waitForPathToExist(&devicePath, 10, iscsiTransport); !exist {
-			glog.Errorf("Could not attach disk: Timeout after 10s")
+		if exist := waitForPathToExist(ctx, &devicePath, b.deviceTimeout, iscsiTransport); !exist {
 			// update last error
-			lastErr = fmt.Errorf("Could not attach disk: Timeout after 10s")
+			if ctx.Err() != nil {
+				lastErr = fmt.Errorf("Could not attach disk: %v", ctx.Err())
+			} else {
+				lastErr = fmt.Errorf("Could not attach disk: Timeout after %v", b.deviceTimeout)
+			}
+			glog.Error(lastErr)
 			continue
 		} else {
 			devicePaths = append(devicePaths, devicePath)
@@ -255,8 +347,11 @@ func (util *ISCSIUtil) AttachDisk(b iscsiDiskMounter) (string, error) {
 	}
 
 	if len(devicePaths) == 0 {
-		// delete cloned iface
-		b.exec.Run("iscsiadm", "-m", "iface", "-I", b.Iface, "-o", "delete")
+		// Log out of the portals which were logged in to and delete the
+		// cloned iface along with the record
+		if err := util.abortAttach(b); err != nil {
+			glog.Errorf("iscsi: failed to clean up after failing to attach volume %s: %v", b.VolName, err)
+		}
 		glog.Errorf("iscsi: failed to get any path for iscsi disk, last err seen:\n%v", lastErr)
 		return "", fmt.Errorf("failed to get any path for iscsi disk, last err seen:\n%v", lastErr)
 	}
@@ -264,101 +359,237 @@ func (util *ISCSIUtil) AttachDisk(b iscsiDiskMounter) (string, error) {
 		glog.Errorf("iscsi: last error occurred during iscsi init:\n%v", lastErr)
 	}
 
-	// Make sure we use a valid devicepath to find mpio device.
+	// Use the first path unless the paths are grouped in a multipath device
 	devicePath = devicePaths[0]
 
-	// Mount device
-	mntPath := b.targetPath
-	notMnt, err := b.mounter.IsLikelyNotMountPoint(mntPath)
-	if err != nil && !os.IsNotExist(err) {
-		return "", fmt.Errorf("Heuristic determination of mount point failed:%v", err)
-	}
-	if !notMnt {
-		glog.Infof("iscsi: %s already mounted", mntPath)
-		return "", nil
+	// Mount device, a volume staged already is checked against the device
+	// by the mounter
+	stagingPath := b.StagingPath
+	mounted := false
+	if b.block {
+		if err := os.MkdirAll(stagingPath, 0750); err != nil {
+			glog.Errorf("iscsi: failed to prepare %s: %v", stagingPath, err)
+			return "", err
+		}
+	} else {
+		mounted, err = b.mounter.PrepareTarget(stagingPath, false)
+		if err != nil {
+			glog.Errorf("iscsi: failed to prepare %s: %v", stagingPath, err)
+			return "", err
+		}
+		if mounted {
+			glog.Infof("iscsi: %s already mounted", stagingPath)
+		}
 	}
 
-	if err := os.MkdirAll(mntPath, 0750); err != nil {
-		glog.Errorf("iscsi: failed to mkdir %s, error", mntPath)
+	// Use the multipath device if the paths are grouped by multipathd,
+	// waiting for all of them if the volume has several portals
+	timeout := time.Duration(0)
+	if len(b.Portals) > 1 {
+		timeout = multipathTimeout
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < timeout {
+			timeout = time.Until(deadline)
+		}
+	}
+	mapper, err := waitForMultipath(b.deviceUtil, devicePaths, len(b.Portals), b.minHealthyPaths, timeout)
+	if err != nil {
+		glog.Errorf("iscsi: %v", err)
 		return "", err
 	}
-
-	// Persist iscsi disk config to json file for DetachDisk path
-	if err := util.persistISCSI(*(b.iscsiDisk), b.targetPath); err != nil {
+	if mapper != "" {
+		devicePath = mapper
+		b.MultipathDevice = mapper
+	}
+	// Record the map to be flushed and the devices to be deleted by
+	// DetachDisk
+	if devices := scsiDevices(devicePaths); len(devices) > 0 {
+		b.Devices = devices
+	}
+	if err := util.persistISCSI(*(b.iscsiDisk)); err != nil {
 		glog.Errorf("iscsi: failed to save iscsi config with error: %v", err)
 		return "", err
 	}
 
-	for _, path := range devicePaths {
-		// There shouldnt be any empty device paths. However adding this check
-		// for safer side to avoid the possibility of an empty entry.
-		if path == "" {
-			continue
-		}
-		// check if the dev is using mpio and if so mount it via the dm-XX device
-		if mappedDevicePath := b.deviceUtil.FindMultipathDeviceForDevice(path); mappedDevicePath != "" {
-			devicePath = mappedDevicePath
-			break
+	// The staged volume is bind mounted read-only by NodePublishVolume if
+	// needed.
+	if b.block {
+		err = b.mounter.MountBlock(devicePath, stagedDevicePath(stagingPath, b.VolName), false)
+		if err != nil {
+			glog.Errorf("iscsi: failed to stage iscsi block volume %s to %s, error %v", devicePath, stagingPath, err)
 		}
+		return devicePath, err
 	}
 
-	var options []string
+	options := csicommon.MountOptions(false, b.mountOptions)
 
-	if b.readOnly {
-		options = append(options, "ro")
-	} else {
-		options = append(options, "rw")
+	err = b.mounter.FormatAndMount(devicePath, stagingPath, b.fsType, options)
+	if err != nil {
+		glog.Errorf("iscsi: failed to mount iscsi volume %s [%s] to %s, error %v", devicePath, b.fsType, stagingPath, err)
+		return devicePath, err
+	}
+	// The file system of a volume staged already may not span the device
+	// yet, the size recorded then is kept for the reconciler to grow it
+	if mounted {
+		return devicePath, nil
 	}
-	options = append(options, b.mountOptions...)
 
-	err = b.mounter.FormatAndMount(devicePath, mntPath, b.fsType, options)
+	// Record the size the file system spans, the reconciler grows it once
+	// the device grows
+	size, err := deviceSize(devicePath)
 	if err != nil {
-		glog.Errorf("iscsi: failed to mount iscsi volume %s [%s] to %s, error %v", devicePath, b.fsType, mntPath, err)
+		glog.Warningf("iscsi: failed to get the size of %s: %v", devicePath, err)
+		return devicePath, nil
 	}
-
-	return devicePath, err
+	b.DeviceSize = size
+	if err := util.persistISCSI(*(b.iscsiDisk)); err != nil {
+		glog.Errorf("iscsi: failed to save iscsi config with error: %v", err)
+		return devicePath, err
+	}
+	return devicePath, nil
 }
 
-func (util *ISCSIUtil) DetachDisk(c iscsiDiskUnmounter, targetPath string) error {
-	_, cnt, err := mount.GetDeviceNameFromMount(c.mounter, targetPath)
+// abortAttach logs out of the sessions of a volume which failed to attach
+// unless other volumes use them, and removes its record.
+func (util *ISCSIUtil) abortAttach(b iscsiDiskMounter) error {
+	sessionLock.Lock()
+	defer sessionLock.Unlock()
+	inUse, err := util.sessionsInUse(b.VolName)
 	if err != nil {
-		glog.Errorf("iscsi detach disk: failed to get device from mnt: %s\nError: %v", targetPath, err)
 		return err
 	}
+	if err := logoutISCSI(b.exec, b.iscsiDisk, inUse); err != nil {
+		return err
+	}
+	return util.removeISCSI(b.VolName)
+}
 
-	if pathExists, pathErr := volumeutil.PathExists(targetPath); pathErr != nil {
+func (util *ISCSIUtil) DetachDisk(c iscsiDiskUnmounter, stagingPath string) error {
+	if pathExists, pathErr := volumeutil.PathExists(stagingPath); pathErr != nil {
 		return fmt.Errorf("Error checking if path exists: %v", pathErr)
 	} else if !pathExists {
-		glog.Warningf("Warning: Unmount skipped because path does not exist: %v", targetPath)
-		return nil
+		glog.Warningf("Warning: Unmount skipped because path does not exist: %v", stagingPath)
+	} else {
+		loggedIn, err := util.unmountStagingPath(c, stagingPath)
+		if err != nil || loggedIn {
+			return err
+		}
 	}
-	if err = c.mounter.Unmount(targetPath); err != nil {
-		glog.Errorf("iscsi detach disk: failed to unmount: %s\nError: %v", targetPath, err)
+
+	// load iscsi disk config, it is removed once the volume is unstaged
+	if err := util.loadISCSI(c.iscsiDisk); err != nil {
+		if os.IsNotExist(err) {
+			glog.V(4).Infof("iscsi detach disk: volume %s is not staged", c.VolName)
+			return nil
+		}
+		glog.Errorf("iscsi detach disk: failed to get iscsi config of volume %s Error: %v", c.VolName, err)
+		return err
+	}
+
+	// Other volumes may be using the sessions of the volume
+	sessionLock.Lock()
+	defer sessionLock.Unlock()
+	inUse, err := util.sessionsInUse(c.VolName)
+	if err != nil {
+		glog.Errorf("iscsi detach disk: failed to count the volumes using the sessions Error: %v", err)
 		return err
 	}
+
+	if err := logoutISCSI(c.exec, c.iscsiDisk, inUse); err != nil {
+		return err
+	}
+
+	if err := util.removeISCSI(c.VolName); err != nil {
+		glog.Errorf("iscsi: failed to remove iscsi config Error: %v", err)
+		return err
+	}
+
+	return nil
+}
+
+// unmountStagingPath unmounts the volume staged on stagingPath and reports
+// whether its device is still mounted elsewhere, i.e. whether the target
+// must stay logged in.
+func (util *ISCSIUtil) unmountStagingPath(c iscsiDiskUnmounter, stagingPath string) (bool, error) {
+	// A file system is staged on the staging path itself, a block volume as
+	// its device bind mounted on a file in the staging path. The mount is
+	// checked first, the file system may hold a file named after the volume.
+	notMnt, err := c.mounter.IsLikelyNotMountPoint(stagingPath)
+	if err != nil {
+		glog.Errorf("iscsi detach disk: failed to check mount point %s\nError: %v", stagingPath, err)
+		return false, err
+	}
+	if notMnt {
+		devicePath := stagedDevicePath(stagingPath, c.VolName)
+		block, err := csicommon.IsBlockTarget(devicePath)
+		if err != nil {
+			glog.Errorf("iscsi detach disk: failed to stat %s\nError: %v", devicePath, err)
+			return false, err
+		}
+		if block {
+			if err := volumeutil.UnmountMountPoint(devicePath, c.mounter, true); err != nil {
+				glog.Errorf("iscsi detach disk: failed to unmount: %s\nError: %v", devicePath, err)
+				return false, err
+			}
+		}
+		return false, nil
+	}
+
+	_, cnt, err := mount.GetDeviceNameFromMount(c.mounter, stagingPath)
+	if err != nil {
+		glog.Errorf("iscsi detach disk: failed to get device from mnt: %s\nError: %v", stagingPath, err)
+		return false, err
+	}
+	if err = c.mounter.Unmount(stagingPath); err != nil {
+		glog.Errorf("iscsi detach disk: failed to unmount: %s\nError: %v", stagingPath, err)
+		return false, err
+	}
 	cnt--
 	if cnt != 0 {
-		return nil
+		glog.Warningf("iscsi detach disk: device of %s is still mounted %d times, not logging out", stagingPath, cnt)
+		return true, nil
 	}
+	return false, nil
+}
 
-	var bkpPortal []string
-	var volName, iqn, iface, initiatorName string
+// logoutISCSI flushes the multipath device of a volume, logs out of its
+// target portals and deletes its node records, as well as the iface cloned
+// for it. The sessions still used by other volumes according to inUse are
+// kept.
+func logoutISCSI(exec mount.Exec, conf *iscsiDisk, inUse map[sessionKey]int) error {
+	if conf.MultipathDevice != "" {
+		if err := flushMultipath(exec, conf.MultipathDevice); err != nil {
+			return err
+		}
+	}
+
+	bkpPortal, iqn, iface, volName := conf.Portals, conf.Iqn, conf.Iface, conf.VolName
+	initiatorName := conf.InitiatorName
 	found := true
 
-	// load iscsi disk config from json file
-	if err := util.loadISCSI(c.iscsiDisk, targetPath); err == nil {
-		bkpPortal, iqn, iface, volName = c.iscsiDisk.Portals, c.iscsiDisk.Iqn, c.iscsiDisk.Iface, c.iscsiDisk.VolName
-		initiatorName = c.iscsiDisk.InitiatorName
-	} else {
-		glog.Errorf("iscsi detach disk: failed to get iscsi config from path %s Error: %v", targetPath, err)
-		return err
-	}
 	portals := removeDuplicate(bkpPortal)
 	if len(portals) == 0 {
 		return fmt.Errorf("iscsi detach disk: failed to detach iscsi disk. Couldn't get connected portals from configurations.")
 	}
 
+	used := false
 	for _, portal := range portals {
+		if inUse[sessionKey{portal: portal, iqn: iqn, iface: iface}] > 0 {
+			used = true
+		}
+	}
+	// The devices of the LUN only go away with the sessions, delete them if
+	// a session is kept for other volumes
+	if used {
+		for _, device := range conf.Devices {
+			deleteSCSIDevice(device)
+		}
+	}
+
+	for _, portal := range portals {
+		if n := inUse[sessionKey{portal: portal, iqn: iqn, iface: iface}]; n > 0 {
+			glog.Infof("iscsi: session of target %s iqn %s iface %s is used by %d other volumes, not logging out", portal, iqn, iface, n)
+			continue
+		}
 		logoutArgs := []string{"-m", "node", "-p", portal, "-T", iqn, "--logout"}
 		deleteArgs := []string{"-m", "node", "-p", portal, "-T", iqn, "-o", "delete"}
 		if found {
@@ -366,33 +597,65 @@ func (util *ISCSIUtil) DetachDisk(c iscsiDiskUnmounter, targetPath string) error
 			deleteArgs = append(deleteArgs, []string{"-I", iface}...)
 		}
 		glog.Infof("iscsi: log out target %s iqn %s iface %s", portal, iqn, iface)
-		out, err := c.exec.Run("iscsiadm", logoutArgs...)
+		out, err := exec.Run("iscsiadm", logoutArgs...)
 		if err != nil {
 			glog.Errorf("iscsi: failed to detach disk Error: %s", string(out))
 		}
 		// Delete the node record
 		glog.Infof("iscsi: delete node record target %s iqn %s", portal, iqn)
-		out, err = c.exec.Run("iscsiadm", deleteArgs...)
+		out, err = exec.Run("iscsiadm", deleteArgs...)
 		if err != nil {
 			glog.Errorf("iscsi: failed to delete node record Error: %s", string(out))
 		}
 	}
 	// Delete the iface after all sessions have logged out
-	// If the iface is not created via iscsi plugin, skip to delete
-	if initiatorName != "" && found && iface == (portals[0]+":"+volName) {
+	// If the iface is not created via iscsi plugin, skip to delete. Ifaces
+	// cloned by earlier versions are named <target portal>:<volume name>,
+	// the record tells they belong to the driver.
+	if initiatorName != "" && found && !used && (iface == clonedIfaceName(portals[0], volName) || iface == portals[0]+":"+volName) {
 		deleteArgs := []string{"-m", "iface", "-I", iface, "-o", "delete"}
-		out, err := c.exec.Run("iscsiadm", deleteArgs...)
+		out, err := exec.Run("iscsiadm", deleteArgs...)
 		if err != nil {
 			glog.Errorf("iscsi: failed to delete iface Error: %s", string(out))
 		}
 	}
+	return nil
+}
 
-	if err := os.RemoveAll(targetPath); err != nil {
-		glog.Errorf("iscsi: failed to remove mount path Error: %v", err)
-		return err
+// scsiDevices returns the SCSI devices, e.g. sdb, devicePaths link to.
+func scsiDevices(devicePaths []string) []string {
+	var devices []string
+	for _, path := range devicePaths {
+		device, err := resolveDevice(path)
+		if err != nil {
+			glog.Warningf("iscsi: failed to resolve device path %s: %v", path, err)
+			continue
+		}
+		devices = append(devices, filepath.Base(device))
 	}
+	return devices
+}
 
-	return nil
+// deleteSCSIDevice removes the SCSI device of a LUN whose session stays
+// logged in. Failures are only logged, the device is left behind as if the
+// session was kept.
+func deleteSCSIDevice(device string) {
+	path := filepath.Join(sysBlockPath, device, "device", "delete")
+	fp, err := os.OpenFile(path, os.O_WRONLY, 0)
+	if os.IsNotExist(err) {
+		glog.V(4).Infof("iscsi: SCSI device %s is gone", device)
+		return
+	}
+	if err == nil {
+		glog.Infof("iscsi: delete SCSI device %s", device)
+		_, err = fp.Write([]byte("1"))
+		if closeErr := fp.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	if err != nil {
+		glog.Warningf("iscsi: failed to delete SCSI device %s: %v", device, err)
+	}
 }
 
 func extractTransportname(ifaceOutput string) (iscsiTransport string) {
@@ -0,0 +1,142 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package iscsi
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+	"k8s.io/kubernetes/pkg/util/mount"
+	"k8s.io/kubernetes/pkg/volume/util"
+)
+
+const (
+	// How long to wait for multipathd to add all the paths of a volume
+	multipathTimeout      = 10 * time.Second
+	multipathPollInterval = time.Second
+)
+
+// sysBlockPath is where the block devices are found in sysfs, tests
+// replace it.
+var sysBlockPath = "/sys/block"
+
+// multipathName returns the name of the device-mapper device dm, e.g.
+// mpatha for /dev/dm-0.
+func multipathName(dm string) (string, error) {
+	name, err := ioutil.ReadFile(filepath.Join(sysBlockPath, filepath.Base(dm), "dm", "name"))
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(name)), nil
+}
+
+// mapperPath returns the /dev/mapper path of the device-mapper device dm,
+// which is stable unlike /dev/dm-N.
+func mapperPath(dm string) string {
+	name, err := multipathName(dm)
+	if err != nil || name == "" {
+		glog.Warningf("iscsi: failed to get the name of multipath device %s, using it directly: %v", dm, err)
+		return dm
+	}
+	return filepath.Join("/dev/mapper", name)
+}
+
+// healthyPaths returns the paths of the multipath device dm whose SCSI
+// device is running.
+func healthyPaths(deviceUtil util.DeviceUtil, dm string) []string {
+	var paths []string
+	for _, slave := range deviceUtil.FindSlaveDevicesOnMultipath(dm) {
+		state, err := ioutil.ReadFile(filepath.Join(sysBlockPath, filepath.Base(slave), "device", "state"))
+		if err != nil {
+			glog.V(4).Infof("iscsi: failed to read the state of path %s of %s: %v", slave, dm, err)
+			continue
+		}
+		if strings.TrimSpace(string(state)) == "running" {
+			paths = append(paths, slave)
+		}
+	}
+	return paths
+}
+
+// waitForMultipath waits up to timeout for the multipath device grouping
+// devicePaths to gain expected healthy paths and returns its /dev/mapper
+// path. Once the timeout expires, the device is used if it has minHealthy
+// healthy paths at least. "" is returned if multipathd does not group the
+// paths and a single healthy path is enough.
+func waitForMultipath(deviceUtil util.DeviceUtil, devicePaths []string, expected, minHealthy int, timeout time.Duration) (string, error) {
+	deadline := time.Now().Add(timeout)
+	for {
+		dm := ""
+		for _, path := range devicePaths {
+			// There shouldnt be any empty device paths. However adding this check
+			// for safer side to avoid the possibility of an empty entry.
+			if path == "" {
+				continue
+			}
+			if dm = deviceUtil.FindMultipathDeviceForDevice(path); dm != "" {
+				break
+			}
+		}
+
+		healthy := 0
+		if dm != "" {
+			healthy = len(healthyPaths(deviceUtil, dm))
+			if healthy >= expected {
+				glog.V(4).Infof("iscsi: multipath device %s has %d healthy paths", dm, healthy)
+				return mapperPath(dm), nil
+			}
+		}
+
+		if !time.Now().Before(deadline) {
+			if dm == "" {
+				if minHealthy > 1 {
+					return "", fmt.Errorf("no multipath device found for %v, %d healthy paths are required", devicePaths, minHealthy)
+				}
+				return "", nil
+			}
+			if healthy < minHealthy {
+				return "", fmt.Errorf("multipath device %s has %d healthy paths, %d are required", dm, healthy, minHealthy)
+			}
+			glog.Warningf("iscsi: multipath device %s has %d healthy paths out of %d", dm, healthy, expected)
+			return mapperPath(dm), nil
+		}
+		time.Sleep(multipathPollInterval)
+	}
+}
+
+// flushMultipath flushes the buffers of the multipath device mapper and
+// removes the map, its paths may only be logged out afterwards.
+func flushMultipath(exec mount.Exec, mapper string) error {
+	if exists, _ := util.PathExists(mapper); !exists {
+		glog.V(4).Infof("iscsi: multipath device %s is gone", mapper)
+		return nil
+	}
+	glog.Infof("iscsi: flush multipath device %s", mapper)
+	out, err := exec.Run("blockdev", "--flushbufs", mapper)
+	if err != nil {
+		glog.Warningf("iscsi: failed to flush the buffers of %s: %s (%v)", mapper, string(out), err)
+	}
+	out, err = exec.Run("multipath", "-f", mapper)
+	if err != nil {
+		return fmt.Errorf("iscsi: failed to flush multipath device %s: %s (%v)", mapper, string(out), err)
+	}
+	return nil
+}
@@ -0,0 +1,131 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package iscsi
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"k8s.io/kubernetes/pkg/util/mount"
+)
+
+// fakeDeviceUtil groups all the paths in /dev/dm-0.
+type fakeDeviceUtil struct {
+	slaves []string
+}
+
+func (f *fakeDeviceUtil) FindMultipathDeviceForDevice(disk string) string {
+	if len(f.slaves) == 0 {
+		return ""
+	}
+	return "/dev/dm-0"
+}
+
+func (f *fakeDeviceUtil) FindSlaveDevicesOnMultipath(disk string) []string {
+	return f.slaves
+}
+
+// fakeSysBlock creates the sysfs entries of the multipath device dm-0 and
+// of its paths, with their state and an empty delete attribute.
+func fakeSysBlock(t *testing.T, paths map[string]string) func() {
+	dir, err := ioutil.TempDir("", "iscsi-sysfs")
+	assert.NoError(t, err)
+	write := func(path, content string) {
+		assert.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+		assert.NoError(t, ioutil.WriteFile(path, []byte(content+"\n"), 0644))
+	}
+	write(filepath.Join(dir, "dm-0", "dm", "name"), "mpatha")
+	for path, state := range paths {
+		write(filepath.Join(dir, path, "device", "state"), state)
+		assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, path, "device", "delete"), nil, 0644))
+	}
+
+	old := sysBlockPath
+	sysBlockPath = dir
+	return func() {
+		sysBlockPath = old
+		os.RemoveAll(dir)
+	}
+}
+
+func TestWaitForMultipath(t *testing.T) {
+	defer fakeSysBlock(t, map[string]string{"sdb": "running", "sdc": "running", "sdd": "offline"})()
+	paths := []string{"/dev/disk/by-path/ip-10.0.0.1:3260-iscsi-iqn-lun-0", "/dev/disk/by-path/ip-10.0.0.2:3260-iscsi-iqn-lun-0"}
+
+	// All paths healthy
+	deviceUtil := &fakeDeviceUtil{slaves: []string{"/dev/sdb", "/dev/sdc"}}
+	mapper, err := waitForMultipath(deviceUtil, paths, 2, 2, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, "/dev/mapper/mpatha", mapper)
+
+	// Degraded but enough healthy paths
+	deviceUtil = &fakeDeviceUtil{slaves: []string{"/dev/sdb", "/dev/sdd"}}
+	mapper, err = waitForMultipath(deviceUtil, paths, 2, 1, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, "/dev/mapper/mpatha", mapper)
+
+	// Not enough healthy paths
+	_, err = waitForMultipath(deviceUtil, paths, 2, 2, 0)
+	assert.Error(t, err)
+
+	// No multipath device
+	deviceUtil = &fakeDeviceUtil{}
+	mapper, err = waitForMultipath(deviceUtil, paths[:1], 1, 1, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, "", mapper)
+	_, err = waitForMultipath(deviceUtil, paths, 2, 2, 0)
+	assert.Error(t, err)
+}
+
+func TestLogoutFlushesMultipath(t *testing.T) {
+	mapper, err := ioutil.TempFile("", "iscsi-mapper")
+	assert.NoError(t, err)
+	mapper.Close()
+	defer os.Remove(mapper.Name())
+
+	var calls []string
+	exec := mount.NewFakeExec(func(cmd string, args ...string) ([]byte, error) {
+		calls = append(calls, cmd+" "+strings.Join(args, " "))
+		return nil, nil
+	})
+	disk := &iscsiDisk{
+		VolName:         "vol1",
+		Portals:         []string{"10.0.0.1:3260"},
+		Iqn:             "iqn.vol1",
+		Iface:           "default",
+		MultipathDevice: mapper.Name(),
+	}
+	assert.NoError(t, logoutISCSI(exec, disk, nil))
+
+	// The map is flushed before logging out
+	assert.Equal(t, []string{
+		"blockdev --flushbufs " + mapper.Name(),
+		"multipath -f " + mapper.Name(),
+		"iscsiadm -m node -p 10.0.0.1:3260 -T iqn.vol1 --logout -I default",
+		"iscsiadm -m node -p 10.0.0.1:3260 -T iqn.vol1 -o delete -I default",
+	}, calls)
+
+	// A map which is gone is not flushed
+	os.Remove(mapper.Name())
+	calls = nil
+	assert.NoError(t, logoutISCSI(exec, disk, nil))
+	assert.Equal(t, 2, len(calls))
+}
@@ -21,47 +21,156 @@ import (
 	"golang.org/x/net/context"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
+	"k8s.io/kubernetes/pkg/util/mount"
+	"k8s.io/kubernetes/pkg/volume/util"
 
 	"github.com/kubernetes-csi/drivers/pkg/csi-common"
 )
 
 type nodeServer struct {
 	*csicommon.DefaultNodeServer
+	volumeLocks *csicommon.VolumeLocks
+	mounter     *csicommon.Mounter
+	exec        mount.Exec
+	deviceUtil  util.DeviceUtil
+	// Directory holding the connection records of the staged volumes
+	stateDir string
 }
 
-func (ns *nodeServer) NodePublishVolume(ctx context.Context, req *csi.NodePublishVolumeRequest) (*csi.NodePublishVolumeResponse, error) {
-	iscsiInfo, err := getISCSIInfo(req)
+func (ns *nodeServer) NodeStageVolume(ctx context.Context, req *csi.NodeStageVolumeRequest) (*csi.NodeStageVolumeResponse, error) {
+	if err := ns.Driver.ValidateNodeServiceRequest(csi.NodeServiceCapability_RPC_STAGE_UNSTAGE_VOLUME); err != nil {
+		return nil, err
+	}
+
+	// Check arguments
+	if len(req.GetVolumeId()) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "Volume ID missing in request")
+	}
+	if len(req.GetStagingTargetPath()) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "Staging target path missing in request")
+	}
+	if req.GetVolumeCapability() == nil {
+		return nil, status.Error(codes.InvalidArgument, "Volume capability missing in request")
+	}
+	if err := ns.Driver.ValidateVolumeCapability(req.GetVolumeCapability()); err != nil {
+		return nil, err
+	}
+
+	// Lock the volume as well as the staging path, iscsiadm must not log in
+	// to the same target twice concurrently.
+	if err := ns.volumeLocks.Acquire(req.GetVolumeId(), req.GetStagingTargetPath()); err != nil {
+		return nil, err
+	}
+	defer ns.volumeLocks.Release(req.GetVolumeId(), req.GetStagingTargetPath())
+
+	iscsiInfo, err := getISCSIInfo(req.GetVolumeId(), req.GetVolumeAttributes(), req.GetNodeStageSecrets())
 	if err != nil {
-		return nil, status.Error(codes.Internal, err.Error())
+		return nil, status.Error(codes.InvalidArgument, err.Error())
 	}
-	diskMounter := getISCSIDiskMounter(iscsiInfo, req)
+	diskMounter := getISCSIDiskMounter(iscsiInfo, req, ns.mounter, ns.exec, ns.deviceUtil)
 
-	util := &ISCSIUtil{}
-	_, err = util.AttachDisk(*diskMounter)
+	iscsiutil := &ISCSIUtil{stateDir: ns.stateDir}
+	_, err = iscsiutil.AttachDisk(ctx, *diskMounter)
 	if err != nil {
+		// The mounter returns gRPC status errors
+		if _, ok := status.FromError(err); ok {
+			return nil, err
+		}
 		return nil, status.Error(codes.Internal, err.Error())
 	}
 
-	return &csi.NodePublishVolumeResponse{}, nil
+	return &csi.NodeStageVolumeResponse{}, nil
 }
 
-func (ns *nodeServer) NodeUnpublishVolume(ctx context.Context, req *csi.NodeUnpublishVolumeRequest) (*csi.NodeUnpublishVolumeResponse, error) {
-	diskUnmounter := getISCSIDiskUnmounter(req)
-	targetPath := req.GetTargetPath()
+func (ns *nodeServer) NodeUnstageVolume(ctx context.Context, req *csi.NodeUnstageVolumeRequest) (*csi.NodeUnstageVolumeResponse, error) {
+	if err := ns.Driver.ValidateNodeServiceRequest(csi.NodeServiceCapability_RPC_STAGE_UNSTAGE_VOLUME); err != nil {
+		return nil, err
+	}
+
+	// Check arguments
+	if len(req.GetVolumeId()) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "Volume ID missing in request")
+	}
+	if len(req.GetStagingTargetPath()) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "Staging target path missing in request")
+	}
 
-	iscsiutil := &ISCSIUtil{}
-	err := iscsiutil.DetachDisk(*diskUnmounter, targetPath)
+	if err := ns.volumeLocks.Acquire(req.GetVolumeId(), req.GetStagingTargetPath()); err != nil {
+		return nil, err
+	}
+	defer ns.volumeLocks.Release(req.GetVolumeId(), req.GetStagingTargetPath())
+
+	diskUnmounter := getISCSIDiskUnmounter(req, ns.mounter.Interface, ns.exec)
+	stagingPath := req.GetStagingTargetPath()
+
+	iscsiutil := &ISCSIUtil{stateDir: ns.stateDir}
+	err := iscsiutil.DetachDisk(*diskUnmounter, stagingPath)
 	if err != nil {
 		return nil, status.Error(codes.Internal, err.Error())
 	}
 
-	return &csi.NodeUnpublishVolumeResponse{}, nil
+	return &csi.NodeUnstageVolumeResponse{}, nil
 }
 
-func (ns *nodeServer) NodeUnstageVolume(ctx context.Context, req *csi.NodeUnstageVolumeRequest) (*csi.NodeUnstageVolumeResponse, error) {
-	return &csi.NodeUnstageVolumeResponse{}, nil
+func (ns *nodeServer) NodePublishVolume(ctx context.Context, req *csi.NodePublishVolumeRequest) (*csi.NodePublishVolumeResponse, error) {
+	// Check arguments
+	if len(req.GetVolumeId()) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "Volume ID missing in request")
+	}
+	if len(req.GetStagingTargetPath()) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "Staging target path missing in request")
+	}
+	if len(req.GetTargetPath()) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "Target path missing in request")
+	}
+	if req.GetVolumeCapability() == nil {
+		return nil, status.Error(codes.InvalidArgument, "Volume capability missing in request")
+	}
+	if err := ns.Driver.ValidateVolumeCapability(req.GetVolumeCapability()); err != nil {
+		return nil, err
+	}
+
+	if err := ns.volumeLocks.Acquire(req.GetVolumeId(), req.GetTargetPath()); err != nil {
+		return nil, err
+	}
+	defer ns.volumeLocks.Release(req.GetVolumeId(), req.GetTargetPath())
+
+	// The volume is attached and mounted by NodeStageVolume, it only has
+	// to be bind mounted on the target path.
+	stagingPath := req.GetStagingTargetPath()
+	targetPath := req.GetTargetPath()
+	if req.GetVolumeCapability().GetBlock() != nil {
+		if err := ns.mounter.MountBlock(stagedDevicePath(stagingPath, req.GetVolumeId()), targetPath, req.GetReadonly()); err != nil {
+			return nil, err
+		}
+		return &csi.NodePublishVolumeResponse{}, nil
+	}
+
+	options := csicommon.MountOptions(req.GetReadonly(), nil, "bind")
+	if err := ns.mounter.Mount(stagingPath, targetPath, "", options); err != nil {
+		return nil, err
+	}
+
+	return &csi.NodePublishVolumeResponse{}, nil
 }
 
-func (ns *nodeServer) NodeStageVolume(ctx context.Context, req *csi.NodeStageVolumeRequest) (*csi.NodeStageVolumeResponse, error) {
-	return &csi.NodeStageVolumeResponse{}, nil
+func (ns *nodeServer) NodeUnpublishVolume(ctx context.Context, req *csi.NodeUnpublishVolumeRequest) (*csi.NodeUnpublishVolumeResponse, error) {
+	// Check arguments
+	if len(req.GetVolumeId()) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "Volume ID missing in request")
+	}
+	if len(req.GetTargetPath()) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "Target path missing in request")
+	}
+
+	if err := ns.volumeLocks.Acquire(req.GetVolumeId(), req.GetTargetPath()); err != nil {
+		return nil, err
+	}
+	defer ns.volumeLocks.Release(req.GetVolumeId(), req.GetTargetPath())
+
+	if err := ns.mounter.Unmount(req.GetTargetPath()); err != nil {
+		return nil, err
+	}
+
+	return &csi.NodeUnpublishVolumeResponse{}, nil
 }
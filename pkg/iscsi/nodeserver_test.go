@@ -0,0 +1,318 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package iscsi
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi/v0"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/kubernetes/pkg/util/mount"
+
+	"github.com/kubernetes-csi/drivers/pkg/csi-common"
+)
+
+const testDevicePath = "/dev/disk/by-path/ip-10.0.0.1:3260-iscsi-iqn.2017-01.io.k8s:vol1-lun-0"
+
+// fakeNode records the commands run by a node server and holds its mounts.
+type fakeNode struct {
+	dir      string
+	commands []string
+	mounter  *optionsMounter
+}
+
+// optionsMounter is a FakeMounter recording the mount options as well.
+type optionsMounter struct {
+	*mount.FakeMounter
+	options map[string][]string
+}
+
+func (m *optionsMounter) Mount(source string, target string, fstype string, options []string) error {
+	m.options[target] = options
+	return m.FakeMounter.Mount(source, target, fstype, options)
+}
+
+// newTestNodeServer returns a node server whose iSCSI devices appear as
+// soon as they are looked for.
+func newTestNodeServer(t *testing.T) (*nodeServer, *fakeNode, func()) {
+	dir, err := ioutil.TempDir("", "iscsi-node")
+	assert.NoError(t, err)
+
+	fake := &fakeNode{dir: dir, mounter: &optionsMounter{&mount.FakeMounter{}, map[string][]string{}}}
+	exec := mount.NewFakeExec(func(cmd string, args ...string) ([]byte, error) {
+		command := strings.Join(append([]string{cmd}, args...), " ")
+		fake.commands = append(fake.commands, command)
+		if strings.HasPrefix(command, "iscsiadm -m iface -I default -o show") {
+			return []byte("iface.transport_name = tcp\n"), nil
+		}
+		return nil, nil
+	})
+
+	d := NewDriver(testInitiator, "", filepath.Join(dir, "state"), nil)
+	ns := NewNodeServer(d)
+	ns.mounter = &csicommon.Mounter{
+		SafeFormatAndMount: &mount.SafeFormatAndMount{Interface: fake.mounter, Exec: exec},
+	}
+	ns.exec = exec
+	ns.deviceUtil = &fakeDeviceUtil{}
+
+	oldStat, oldResolve := statDevice, resolveDevice
+	statDevice = func(string) (os.FileInfo, error) { return nil, nil }
+	resolveDevice = func(string) (string, error) { return "/dev/sdb", nil }
+	return ns, fake, func() {
+		statDevice, resolveDevice = oldStat, oldResolve
+		os.RemoveAll(dir)
+	}
+}
+
+// mountPoint returns the fake mount on path.
+func (f *fakeNode) mountPoint(path string) *mount.MountPoint {
+	for i := range f.mounter.MountPoints {
+		if f.mounter.MountPoints[i].Path == path {
+			return &f.mounter.MountPoints[i]
+		}
+	}
+	return nil
+}
+
+func blockCapability() *csi.VolumeCapability {
+	return &csi.VolumeCapability{
+		AccessType: &csi.VolumeCapability_Block{Block: &csi.VolumeCapability_BlockVolume{}},
+		AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER},
+	}
+}
+
+func (f *fakeNode) stageRequest(capability *csi.VolumeCapability) *csi.NodeStageVolumeRequest {
+	return &csi.NodeStageVolumeRequest{
+		VolumeId:          "vol1",
+		StagingTargetPath: filepath.Join(f.dir, "staging"),
+		VolumeCapability:  capability,
+		VolumeAttributes:  fakeAttributes(map[string]string{"portals": "[]", "iscsiInterface": "default"}),
+	}
+}
+
+func (f *fakeNode) publishRequest(capability *csi.VolumeCapability, readonly bool) *csi.NodePublishVolumeRequest {
+	return &csi.NodePublishVolumeRequest{
+		VolumeId:          "vol1",
+		StagingTargetPath: filepath.Join(f.dir, "staging"),
+		TargetPath:        filepath.Join(f.dir, "target"),
+		VolumeCapability:  capability,
+		Readonly:          readonly,
+	}
+}
+
+func TestNodeMissingArguments(t *testing.T) {
+	ns, fake, cleanup := newTestNodeServer(t)
+	defer cleanup()
+
+	stage := fake.stageRequest(mountCapability())
+	publish := fake.publishRequest(mountCapability(), false)
+	calls := map[string]func() error{
+		"stage without volume ID": func() error {
+			req := *stage
+			req.VolumeId = ""
+			_, err := ns.NodeStageVolume(context.Background(), &req)
+			return err
+		},
+		"stage without staging path": func() error {
+			req := *stage
+			req.StagingTargetPath = ""
+			_, err := ns.NodeStageVolume(context.Background(), &req)
+			return err
+		},
+		"stage without capability": func() error {
+			req := *stage
+			req.VolumeCapability = nil
+			_, err := ns.NodeStageVolume(context.Background(), &req)
+			return err
+		},
+		"unstage without volume ID": func() error {
+			_, err := ns.NodeUnstageVolume(context.Background(), &csi.NodeUnstageVolumeRequest{StagingTargetPath: stage.StagingTargetPath})
+			return err
+		},
+		"unstage without staging path": func() error {
+			_, err := ns.NodeUnstageVolume(context.Background(), &csi.NodeUnstageVolumeRequest{VolumeId: "vol1"})
+			return err
+		},
+		"publish without staging path": func() error {
+			req := *publish
+			req.StagingTargetPath = ""
+			_, err := ns.NodePublishVolume(context.Background(), &req)
+			return err
+		},
+		"publish without target path": func() error {
+			req := *publish
+			req.TargetPath = ""
+			_, err := ns.NodePublishVolume(context.Background(), &req)
+			return err
+		},
+		"unpublish without target path": func() error {
+			_, err := ns.NodeUnpublishVolume(context.Background(), &csi.NodeUnpublishVolumeRequest{VolumeId: "vol1"})
+			return err
+		},
+	}
+	for name, call := range calls {
+		assert.Equal(t, codes.InvalidArgument, status.Code(call()), name)
+	}
+	assert.Empty(t, fake.commands)
+	assert.Empty(t, fake.mounter.MountPoints)
+}
+
+func TestNodeStageVolume(t *testing.T) {
+	ns, fake, cleanup := newTestNodeServer(t)
+	defer cleanup()
+	defer fakeSysBlock(t, nil)()
+	setDeviceSize(t, filepath.Base(testDevicePath), gibSectors)
+
+	req := fake.stageRequest(mountCapability())
+	_, err := ns.NodeStageVolume(context.Background(), req)
+	assert.NoError(t, err)
+	assert.Equal(t, []mount.MountPoint{{Device: testDevicePath, Path: req.StagingTargetPath}}, fake.mounter.MountPoints)
+	disks, err := (&ISCSIUtil{stateDir: ns.stateDir}).listISCSI()
+	assert.NoError(t, err)
+	if assert.Equal(t, 1, len(disks)) {
+		assert.Equal(t, []string{"sdb"}, disks[0].Devices)
+		assert.Equal(t, int64(gibSectors*512), disks[0].DeviceSize)
+	}
+
+	// Staging again keeps the mount and the record, the file system has
+	// the size recorded then until the reconciler grows it
+	setDeviceSize(t, filepath.Base(testDevicePath), 2*gibSectors)
+	_, err = ns.NodeStageVolume(context.Background(), req)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(fake.mounter.MountPoints))
+	disks, err = (&ISCSIUtil{stateDir: ns.stateDir}).listISCSI()
+	assert.NoError(t, err)
+	if assert.Equal(t, 1, len(disks)) {
+		assert.Equal(t, []string{"sdb"}, disks[0].Devices)
+		assert.Equal(t, int64(gibSectors*512), disks[0].DeviceSize)
+	}
+}
+
+func TestNodeStageVolumeMountedElsewhere(t *testing.T) {
+	ns, fake, cleanup := newTestNodeServer(t)
+	defer cleanup()
+
+	// The staging path is mounted from another device
+	req := fake.stageRequest(mountCapability())
+	assert.NoError(t, os.MkdirAll(req.StagingTargetPath, 0750))
+	fake.mounter.MountPoints = []mount.MountPoint{{Device: "/dev/sdc", Path: req.StagingTargetPath}}
+	_, err := ns.NodeStageVolume(context.Background(), req)
+	assert.Equal(t, codes.AlreadyExists, status.Code(err))
+	assert.Equal(t, 1, len(fake.mounter.MountPoints))
+}
+
+func TestNodePublishVolume(t *testing.T) {
+	ns, fake, cleanup := newTestNodeServer(t)
+	defer cleanup()
+
+	_, err := ns.NodeStageVolume(context.Background(), fake.stageRequest(mountCapability()))
+	assert.NoError(t, err)
+
+	// The staging path is bind mounted
+	req := fake.publishRequest(mountCapability(), false)
+	_, err = ns.NodePublishVolume(context.Background(), req)
+	assert.NoError(t, err)
+	mp := fake.mountPoint(req.TargetPath)
+	if assert.NotNil(t, mp) {
+		assert.Equal(t, testDevicePath, mp.Device)
+	}
+	assert.Equal(t, []string{"bind", "rw"}, fake.mounter.options[req.TargetPath])
+	_, err = ns.NodeUnpublishVolume(context.Background(), &csi.NodeUnpublishVolumeRequest{VolumeId: "vol1", TargetPath: req.TargetPath})
+	assert.NoError(t, err)
+	assert.Nil(t, fake.mountPoint(req.TargetPath))
+
+	// Read-only
+	req = fake.publishRequest(mountCapability(), true)
+	_, err = ns.NodePublishVolume(context.Background(), req)
+	assert.NoError(t, err)
+	assert.NotNil(t, fake.mountPoint(req.TargetPath))
+	assert.Equal(t, []string{"bind", "ro"}, fake.mounter.options[req.TargetPath])
+}
+
+func TestNodePublishBlockVolume(t *testing.T) {
+	ns, fake, cleanup := newTestNodeServer(t)
+	defer cleanup()
+
+	stage := fake.stageRequest(blockCapability())
+	_, err := ns.NodeStageVolume(context.Background(), stage)
+	assert.NoError(t, err)
+	staged := stagedDevicePath(stage.StagingTargetPath, "vol1")
+	assert.NotNil(t, fake.mountPoint(staged))
+
+	// The device bind mounted in the staging path is published
+	req := fake.publishRequest(blockCapability(), true)
+	_, err = ns.NodePublishVolume(context.Background(), req)
+	assert.NoError(t, err)
+	mp := fake.mountPoint(req.TargetPath)
+	if assert.NotNil(t, mp) {
+		assert.Equal(t, testDevicePath, mp.Device)
+	}
+	assert.Equal(t, []string{"bind", "ro"}, fake.mounter.options[req.TargetPath])
+}
+
+func TestNodeUnstageVolume(t *testing.T) {
+	ns, fake, cleanup := newTestNodeServer(t)
+	defer cleanup()
+
+	stage := fake.stageRequest(mountCapability())
+	_, err := ns.NodeStageVolume(context.Background(), stage)
+	assert.NoError(t, err)
+	fake.commands = nil
+
+	_, err = ns.NodeUnstageVolume(context.Background(), &csi.NodeUnstageVolumeRequest{VolumeId: "vol1", StagingTargetPath: stage.StagingTargetPath})
+	assert.NoError(t, err)
+	assert.Empty(t, fake.mounter.MountPoints)
+	assert.Equal(t, []string{
+		"iscsiadm -m node -p 10.0.0.1:3260 -T iqn.2017-01.io.k8s:vol1 --logout -I default",
+		"iscsiadm -m node -p 10.0.0.1:3260 -T iqn.2017-01.io.k8s:vol1 -o delete -I default",
+	}, fake.commands)
+	disks, err := (&ISCSIUtil{stateDir: ns.stateDir}).listISCSI()
+	assert.NoError(t, err)
+	assert.Empty(t, disks)
+
+	// Unstaging again is a no-op
+	fake.commands = nil
+	_, err = ns.NodeUnstageVolume(context.Background(), &csi.NodeUnstageVolumeRequest{VolumeId: "vol1", StagingTargetPath: stage.StagingTargetPath})
+	assert.NoError(t, err)
+	assert.Empty(t, fake.commands)
+}
+
+func TestNodeUnstageVolumeFileNamedAfterVolume(t *testing.T) {
+	ns, fake, cleanup := newTestNodeServer(t)
+	defer cleanup()
+
+	stage := fake.stageRequest(mountCapability())
+	_, err := ns.NodeStageVolume(context.Background(), stage)
+	assert.NoError(t, err)
+	// A file of the user named like the file block volumes are staged on
+	file := stagedDevicePath(stage.StagingTargetPath, "vol1")
+	assert.NoError(t, ioutil.WriteFile(file, []byte("data"), 0644))
+
+	_, err = ns.NodeUnstageVolume(context.Background(), &csi.NodeUnstageVolumeRequest{VolumeId: "vol1", StagingTargetPath: stage.StagingTargetPath})
+	assert.NoError(t, err)
+	assert.Empty(t, fake.mounter.MountPoints)
+	_, err = os.Stat(file)
+	assert.NoError(t, err)
+}
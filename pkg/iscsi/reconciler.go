@@ -0,0 +1,365 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package iscsi
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+	"k8s.io/kubernetes/pkg/util/mount"
+	"k8s.io/kubernetes/pkg/volume/util"
+
+	"github.com/kubernetes-csi/drivers/pkg/csi-common"
+)
+
+// iscsiSession is a session listed by iscsiadm.
+type iscsiSession struct {
+	sessionKey
+	sid string
+}
+
+// Reconciler cleans up the iSCSI sessions and ifaces leaked when the
+// driver stops between logging in to a target and staging the volume, or
+// between unstaging it and logging out. It also grows the staged volumes
+// whose LUN grew.
+type Reconciler struct {
+	util       *ISCSIUtil
+	mounter    mount.Interface
+	exec       mount.Exec
+	deviceUtil util.DeviceUtil
+	// Volumes with a node operation in flight are left alone
+	volumeLocks *csicommon.VolumeLocks
+	// Only report the orphans, do not clean them up
+	dryRun bool
+}
+
+func NewReconciler(stateDir string, volumeLocks *csicommon.VolumeLocks, dryRun bool) *Reconciler {
+	return &Reconciler{
+		util:        &ISCSIUtil{stateDir: stateDir},
+		mounter:     mount.New(""),
+		exec:        mount.NewOsExec(),
+		deviceUtil:  util.NewDeviceHandler(util.NewIOHandler()),
+		volumeLocks: volumeLocks,
+		dryRun:      dryRun,
+	}
+}
+
+// Run reconciles every period until stop is closed.
+func (r *Reconciler) Run(period time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(period)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			r.Reconcile()
+		case <-stop:
+			return
+		}
+	}
+}
+
+// Reconcile rescans the sessions and logs out of the targets of the
+// recorded volumes which are not staged anymore, then of the sessions of the
+// ifaces cloned for volumes which are not recorded, and deletes these ifaces.
+// Sessions of the other ifaces which no volume uses are only reported, they
+// may have been logged in by someone else. The staged volumes are grown
+// last.
+func (r *Reconciler) Reconcile() {
+	r.reconcileSessions()
+	r.expandVolumes()
+}
+
+func (r *Reconciler) reconcileSessions() {
+	sessionLock.Lock()
+	defer sessionLock.Unlock()
+
+	// Rescanning updates the size of the devices of the LUNs which grew,
+	// the dry run changes nothing.
+	if !r.dryRun {
+		if err := rescanSessions(r.exec); err != nil {
+			glog.Error(err)
+		}
+	}
+	r.reconcileRecords()
+
+	disks, err := r.util.listISCSI()
+	if err != nil {
+		glog.Errorf("iscsi: failed to read connection records from %s: %v", r.util.stateDir, err)
+		return
+	}
+	sessions, err := r.listSessions()
+	if err != nil {
+		glog.Errorf("iscsi: failed to list sessions: %v", err)
+		return
+	}
+	ifaces, err := r.listIfaces()
+	if err != nil {
+		glog.Errorf("iscsi: failed to list ifaces: %v", err)
+		return
+	}
+
+	recorded := map[sessionKey]bool{}
+	recordedIfaces := map[string]bool{}
+	for _, disk := range disks {
+		for _, key := range sessionKeys(disk) {
+			recorded[key] = true
+		}
+		recordedIfaces[disk.Iface] = true
+	}
+
+	// Ifaces still used by a session once the orphans are logged out
+	usedIfaces := map[string]bool{}
+	for _, s := range sessions {
+		switch {
+		case isClonedIface(s.iface) && !recordedIfaces[s.iface]:
+			// The portal of a cloned iface may be a host name while
+			// iscsiadm lists addresses, the iface identifies the volume.
+			if !r.logoutSession(s) {
+				usedIfaces[s.iface] = true
+			}
+		case !isClonedIface(s.iface) && !recorded[s.sessionKey]:
+			glog.V(2).Infof("iscsi: session %s of target %s iqn %s iface %s is not used by any volume of the driver", s.sid, s.portal, s.iqn, s.iface)
+			usedIfaces[s.iface] = true
+		default:
+			usedIfaces[s.iface] = true
+		}
+	}
+
+	for _, iface := range ifaces {
+		if !isClonedIface(iface) || recordedIfaces[iface] || usedIfaces[iface] {
+			continue
+		}
+		if r.dryRun {
+			glog.Infof("iscsi: dry run: would delete orphaned iface %s", iface)
+			continue
+		}
+		glog.Infof("iscsi: deleting orphaned iface %s", iface)
+		if out, err := r.exec.Run("iscsiadm", "-m", "iface", "-I", iface, "-o", "delete"); err != nil {
+			glog.Errorf("iscsi: failed to delete iface %s: %s (%v)", iface, string(out), err)
+		}
+	}
+}
+
+// reconcileRecords logs out of the targets of the recorded volumes which
+// are not staged anymore, e.g. because the node rebooted or the driver was
+// stopped while unstaging them. sessionLock must be held.
+func (r *Reconciler) reconcileRecords() {
+	disks, err := r.util.listISCSI()
+	if err != nil {
+		glog.Errorf("iscsi: failed to read connection records from %s: %v", r.util.stateDir, err)
+		return
+	}
+
+	for _, disk := range disks {
+		if r.volumeLocks != nil {
+			if !r.volumeLocks.TryAcquire(disk.VolName) {
+				glog.V(4).Infof("iscsi: volume %s is being staged or unstaged, skipping it", disk.VolName)
+				continue
+			}
+		}
+		r.reconcileRecord(disk)
+		if r.volumeLocks != nil {
+			r.volumeLocks.Release(disk.VolName)
+		}
+	}
+}
+
+func (r *Reconciler) reconcileRecord(disk *iscsiDisk) {
+	staged, err := isStaged(r.mounter, disk)
+	if err != nil {
+		glog.Errorf("iscsi: failed to check whether volume %s is staged: %v", disk.VolName, err)
+		return
+	}
+	if staged {
+		glog.V(4).Infof("iscsi: volume %s is staged on %s", disk.VolName, disk.StagingPath)
+		return
+	}
+	// DetachDisk keeps the session of a volume whose device is still
+	// mounted elsewhere
+	mounted, err := deviceMounted(r.mounter, disk)
+	if err != nil {
+		glog.Errorf("iscsi: failed to check whether the device of volume %s is mounted: %v", disk.VolName, err)
+		return
+	}
+	if mounted {
+		glog.V(2).Infof("iscsi: volume %s is not staged but its device is still mounted, not logging out", disk.VolName)
+		return
+	}
+	if r.dryRun {
+		glog.Infof("iscsi: dry run: volume %s is not staged anymore, would log out", disk.VolName)
+		return
+	}
+
+	// The records are removed as the volumes are processed, the last
+	// volume not staged anymore using a session logs out of it.
+	inUse, err := r.util.sessionsInUse(disk.VolName)
+	if err != nil {
+		glog.Errorf("iscsi: failed to count the volumes using the sessions of volume %s: %v", disk.VolName, err)
+		return
+	}
+	glog.Infof("iscsi: volume %s is not staged anymore, logging out", disk.VolName)
+	if err := logoutISCSI(r.exec, disk, inUse); err != nil {
+		glog.Errorf("iscsi: failed to log out volume %s: %v", disk.VolName, err)
+		return
+	}
+	if err := r.util.removeISCSI(disk.VolName); err != nil {
+		glog.Errorf("iscsi: failed to remove connection record of volume %s: %v", disk.VolName, err)
+	}
+}
+
+// expandVolumes grows the staged volumes whose LUN grew. Growing runs
+// multipathd and the file system tools, it only holds the lock of the
+// volume rather than sessionLock.
+func (r *Reconciler) expandVolumes() {
+	disks, err := r.util.listISCSI()
+	if err != nil {
+		glog.Errorf("iscsi: failed to read connection records from %s: %v", r.util.stateDir, err)
+		return
+	}
+
+	for _, disk := range disks {
+		if r.volumeLocks != nil {
+			if !r.volumeLocks.TryAcquire(disk.VolName) {
+				glog.V(4).Infof("iscsi: volume %s is being staged or unstaged, skipping it", disk.VolName)
+				continue
+			}
+		}
+		r.expandVolume(disk)
+		if r.volumeLocks != nil {
+			r.volumeLocks.Release(disk.VolName)
+		}
+	}
+}
+
+// expandVolume grows a staged volume to the size of its LUN.
+func (r *Reconciler) expandVolume(disk *iscsiDisk) {
+	// The volume may have been unstaged since the records were listed
+	if err := r.util.loadISCSI(disk); err != nil {
+		if !os.IsNotExist(err) {
+			glog.Errorf("iscsi: failed to read connection record of volume %s: %v", disk.VolName, err)
+		}
+		return
+	}
+	staged, err := isStaged(r.mounter, disk)
+	if err != nil {
+		glog.Errorf("iscsi: failed to check whether volume %s is staged: %v", disk.VolName, err)
+		return
+	}
+	if !staged {
+		return
+	}
+
+	changed, err := expandVolume(r.exec, r.mounter, r.deviceUtil, disk, r.dryRun)
+	if err != nil {
+		glog.Errorf("iscsi: failed to expand volume %s: %v", disk.VolName, err)
+		return
+	}
+	if changed {
+		if err := r.util.persistISCSI(*disk); err != nil {
+			glog.Errorf("iscsi: failed to record the size of volume %s: %v", disk.VolName, err)
+		}
+	}
+}
+
+// logoutSession logs out of an orphaned session and deletes its node
+// record, it reports whether the session is gone.
+func (r *Reconciler) logoutSession(s iscsiSession) bool {
+	if r.dryRun {
+		glog.Infof("iscsi: dry run: would log out orphaned session %s of target %s iqn %s iface %s", s.sid, s.portal, s.iqn, s.iface)
+		return false
+	}
+	glog.Infof("iscsi: logging out orphaned session %s of target %s iqn %s iface %s", s.sid, s.portal, s.iqn, s.iface)
+	if out, err := r.exec.Run("iscsiadm", "-m", "node", "-p", s.portal, "-T", s.iqn, "-I", s.iface, "--logout"); err != nil {
+		glog.Errorf("iscsi: failed to log out session %s: %s (%v)", s.sid, string(out), err)
+		return false
+	}
+	if out, err := r.exec.Run("iscsiadm", "-m", "node", "-p", s.portal, "-T", s.iqn, "-I", s.iface, "-o", "delete"); err != nil {
+		glog.Errorf("iscsi: failed to delete node record of session %s: %s (%v)", s.sid, string(out), err)
+	}
+	return true
+}
+
+// listSessions lists the sessions of the node, along with their iface.
+func (r *Reconciler) listSessions() ([]iscsiSession, error) {
+	out, err := r.exec.Run("iscsiadm", "-m", "session", "-P", "1")
+	if err != nil {
+		if strings.Contains(string(out), "No active sessions") {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("iscsiadm -m session failed: %s (%v)", string(out), err)
+	}
+	return parseSessions(string(out)), nil
+}
+
+// parseSessions parses the output of iscsiadm -m session -P 1:
+//
+//	Target: iqn.2017-01.io.k8s:target (non-flash)
+//		Current Portal: 10.0.0.1:3260,1
+//		Persistent Portal: 10.0.0.1:3260,1
+//			...
+//			Iface Name: default
+//			...
+//			SID: 1
+func parseSessions(output string) []iscsiSession {
+	var sessions []iscsiSession
+	var s iscsiSession
+	for _, line := range strings.Split(output, "\n") {
+		line = strings.TrimSpace(line)
+		i := strings.Index(line, ":")
+		if i < 0 {
+			continue
+		}
+		key, value := line[:i], strings.TrimSpace(line[i+1:])
+		switch key {
+		case "Target":
+			s = iscsiSession{}
+			if fields := strings.Fields(value); len(fields) > 0 {
+				s.iqn = fields[0]
+			}
+		case "Persistent Portal":
+			// Drop the portal group tag
+			if j := strings.LastIndex(value, ","); j >= 0 {
+				value = value[:j]
+			}
+			s.portal = value
+		case "Iface Name":
+			s.iface = value
+		case "SID":
+			s.sid = value
+			sessions = append(sessions, s)
+		}
+	}
+	return sessions
+}
+
+// listIfaces lists the names of the ifaces of the node.
+func (r *Reconciler) listIfaces() ([]string, error) {
+	out, err := r.exec.Run("iscsiadm", "-m", "iface")
+	if err != nil {
+		return nil, fmt.Errorf("iscsiadm -m iface failed: %s (%v)", string(out), err)
+	}
+	var ifaces []string
+	for _, line := range strings.Split(string(out), "\n") {
+		if fields := strings.Fields(line); len(fields) > 0 {
+			ifaces = append(ifaces, fields[0])
+		}
+	}
+	return ifaces, nil
+}
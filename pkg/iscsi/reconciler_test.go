@@ -0,0 +1,231 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package iscsi
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"k8s.io/kubernetes/pkg/util/mount"
+
+	"github.com/kubernetes-csi/drivers/pkg/csi-common"
+)
+
+const testSessionOutput = `Target: iqn.2017-01.io.k8s:staged (non-flash)
+	Current Portal: 10.0.0.1:3260,1
+	Persistent Portal: 10.0.0.1:3260,1
+		**********
+		Interface:
+		**********
+		Iface Name: default
+		Iface Transport: tcp
+		Iface Initiatorname: iqn.1993-08.org.debian:01:node
+		Iface IPaddress: 10.0.0.10
+		Iface HWaddress: <empty>
+		Iface Netdev: <empty>
+		SID: 1
+		iSCSI Connection State: LOGGED IN
+		iSCSI Session State: LOGGED_IN
+		Internal iscsid Session State: NO CHANGE
+Target: iqn.2017-01.io.k8s:leaked (non-flash)
+	Current Portal: 10.0.0.1:3260,1
+	Persistent Portal: 10.0.0.1:3260,1
+		**********
+		Interface:
+		**********
+		Iface Name: csi-iscsi:10.0.0.1:3260:leaked
+		Iface Transport: tcp
+		SID: 2
+		iSCSI Connection State: LOGGED IN
+Target: iqn.2017-01.io.k8s:other (non-flash)
+	Current Portal: 10.0.0.2:3260,1
+	Persistent Portal: 10.0.0.2:3260,1
+		**********
+		Interface:
+		**********
+		Iface Name: default
+		SID: 3
+Target: iqn.2017-01.io.k8s:pv-x (non-flash)
+	Current Portal: 10.0.0.1:3260,1
+	Persistent Portal: 10.0.0.1:3260,1
+		**********
+		Interface:
+		**********
+		Iface Name: 10.0.0.1:3260:pv-x
+		SID: 4
+`
+
+const testIfaceOutput = `default tcp,<empty>,<empty>,<empty>,<empty>
+iser iser,<empty>,<empty>,<empty>,<empty>
+bnx2i.00:10:18:a3:8b:4b bnx2i,00:10:18:a3:8b:4b,<empty>,<empty>,<empty>
+csi-iscsi:10.0.0.1:3260:leaked tcp,<empty>,<empty>,<empty>,iqn.2017-01.io.k8s:node
+csi-iscsi:10.0.0.1:3260:idle tcp,<empty>,<empty>,<empty>,iqn.2017-01.io.k8s:node
+10.0.0.1:3260:pv-x tcp,<empty>,<empty>,<empty>,iqn.2017-01.io.k8s:node
+`
+
+func TestParseSessions(t *testing.T) {
+	assert.Equal(t, []iscsiSession{
+		{sessionKey{portal: "10.0.0.1:3260", iqn: "iqn.2017-01.io.k8s:staged", iface: "default"}, "1"},
+		{sessionKey{portal: "10.0.0.1:3260", iqn: "iqn.2017-01.io.k8s:leaked", iface: "csi-iscsi:10.0.0.1:3260:leaked"}, "2"},
+		{sessionKey{portal: "10.0.0.2:3260", iqn: "iqn.2017-01.io.k8s:other", iface: "default"}, "3"},
+		{sessionKey{portal: "10.0.0.1:3260", iqn: "iqn.2017-01.io.k8s:pv-x", iface: "10.0.0.1:3260:pv-x"}, "4"},
+	}, parseSessions(testSessionOutput))
+}
+
+func TestIsClonedIface(t *testing.T) {
+	assert.True(t, isClonedIface(clonedIfaceName("10.0.0.1:3260", "vol1")))
+	assert.True(t, isClonedIface("csi-iscsi:[fd00::1]:3260:vol1"))
+	assert.True(t, isClonedIface("csi-iscsi:target.example.com:3260:pool/vol1"))
+	assert.False(t, isClonedIface("default"))
+	assert.False(t, isClonedIface("iser"))
+	assert.False(t, isClonedIface("bnx2i.00:10:18:a3:8b:4b"))
+	// Cloned by the in-tree plugin
+	assert.False(t, isClonedIface("10.0.0.1:3260:pv-x"))
+}
+
+func newTestReconciler(t *testing.T, dryRun bool) (*Reconciler, *[]string, func()) {
+	dir, err := ioutil.TempDir("", "iscsi-state")
+	assert.NoError(t, err)
+
+	staged := filepath.Join(dir, "staged")
+	assert.NoError(t, os.Mkdir(staged, 0750))
+	util := &ISCSIUtil{stateDir: filepath.Join(dir, "state")}
+	assert.NoError(t, util.persistISCSI(iscsiDisk{VolName: "staged", Portals: []string{"10.0.0.1:3260"}, Iqn: "iqn.2017-01.io.k8s:staged", Iface: "default", StagingPath: staged}))
+
+	var calls []string
+	exec := mount.NewFakeExec(func(cmd string, args ...string) ([]byte, error) {
+		call := cmd + " " + strings.Join(args, " ")
+		switch call {
+		case "iscsiadm -m session -P 1":
+			return []byte(testSessionOutput), nil
+		case "iscsiadm -m iface":
+			return []byte(testIfaceOutput), nil
+		}
+		calls = append(calls, call)
+		return nil, nil
+	})
+	r := &Reconciler{
+		util:        util,
+		mounter:     &mount.FakeMounter{MountPoints: []mount.MountPoint{{Device: "/dev/sdb", Path: staged}}},
+		exec:        exec,
+		volumeLocks: csicommon.NewVolumeLocks(),
+		dryRun:      dryRun,
+	}
+	return r, &calls, func() { os.RemoveAll(dir) }
+}
+
+func TestReconcileOrphans(t *testing.T) {
+	r, calls, cleanup := newTestReconciler(t, false)
+	defer cleanup()
+
+	r.Reconcile()
+
+	// The session of the cloned iface no volume is recorded for is logged
+	// out and the unused cloned ifaces are deleted. The unrecorded sessions
+	// of the default iface and of the iface cloned by the in-tree plugin are
+	// left alone.
+	assert.Equal(t, []string{
+		"iscsiadm -m session -R",
+		"iscsiadm -m node -p 10.0.0.1:3260 -T iqn.2017-01.io.k8s:leaked -I csi-iscsi:10.0.0.1:3260:leaked --logout",
+		"iscsiadm -m node -p 10.0.0.1:3260 -T iqn.2017-01.io.k8s:leaked -I csi-iscsi:10.0.0.1:3260:leaked -o delete",
+		"iscsiadm -m iface -I csi-iscsi:10.0.0.1:3260:leaked -o delete",
+		"iscsiadm -m iface -I csi-iscsi:10.0.0.1:3260:idle -o delete",
+	}, *calls)
+}
+
+func TestReconcileDryRun(t *testing.T) {
+	r, calls, cleanup := newTestReconciler(t, true)
+	defer cleanup()
+	assert.NoError(t, r.util.persistISCSI(iscsiDisk{VolName: "unstaged", Portals: []string{"10.0.0.3:3260"}, Iqn: "iqn.2017-01.io.k8s:unstaged", Iface: "default"}))
+
+	r.Reconcile()
+
+	// Nothing is cleaned up, the record of the unstaged volume is kept
+	assert.Empty(t, *calls)
+	disks, err := r.util.listISCSI()
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(disks))
+}
+
+func TestReconcileSkipsLockedVolumes(t *testing.T) {
+	r, calls, cleanup := newTestReconciler(t, false)
+	defer cleanup()
+	// A volume being staged is recorded before it is mounted
+	assert.NoError(t, r.util.persistISCSI(iscsiDisk{VolName: "staging", Portals: []string{"10.0.0.1:3260"}, Iqn: "iqn.2017-01.io.k8s:leaked", Iface: "csi-iscsi:10.0.0.1:3260:leaked"}))
+	assert.True(t, r.volumeLocks.TryAcquire("staging"))
+
+	r.Reconcile()
+
+	assert.Equal(t, []string{
+		"iscsiadm -m session -R",
+		"iscsiadm -m iface -I csi-iscsi:10.0.0.1:3260:idle -o delete",
+	}, *calls)
+	disks, err := r.util.listISCSI()
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(disks))
+}
+
+func TestReconcileExpandsVolumes(t *testing.T) {
+	defer fakeSysBlock(t, nil)()
+	setDeviceSize(t, "sdb", 2*gibSectors)
+	r, calls, cleanup := newTestReconciler(t, false)
+	defer cleanup()
+	r.mounter.(*mount.FakeMounter).MountPoints[0].Type = "ext4"
+
+	// Growing the volumes does not hold sessionLock
+	sessionLock.Lock()
+	r.expandVolumes()
+	sessionLock.Unlock()
+	assert.Equal(t, []string{"resize2fs /dev/sdb"}, *calls)
+	disk := &iscsiDisk{VolName: "staged"}
+	assert.NoError(t, r.util.loadISCSI(disk))
+	assert.Equal(t, int64(2*gibSectors*512), disk.DeviceSize)
+
+	// A volume being staged or unstaged is left alone
+	setDeviceSize(t, "sdb", 3*gibSectors)
+	*calls = nil
+	assert.True(t, r.volumeLocks.TryAcquire("staged"))
+	r.expandVolumes()
+	assert.Empty(t, *calls)
+}
+
+func TestReconcileDeviceMountedElsewhere(t *testing.T) {
+	r, calls, cleanup := newTestReconciler(t, false)
+	defer cleanup()
+	// The staging path was unmounted but the device is still mounted
+	assert.NoError(t, r.util.persistISCSI(iscsiDisk{VolName: "unstaged", Portals: []string{"10.0.0.3:3260"}, Iqn: "iqn.2017-01.io.k8s:unstaged", Iface: "default", StagingPath: "/staging/unstaged", Devices: []string{"sdc"}}))
+	fake := r.mounter.(*mount.FakeMounter)
+	fake.MountPoints = append(fake.MountPoints, mount.MountPoint{Device: "/dev/sdc", Path: "/pods/target"})
+
+	r.reconcileRecords()
+	assert.Empty(t, *calls)
+	disks, err := r.util.listISCSI()
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(disks))
+
+	// Logged out once the device is unmounted
+	fake.MountPoints = fake.MountPoints[:1]
+	r.reconcileRecords()
+	assert.Equal(t, []string{
+		"iscsiadm -m node -p 10.0.0.3:3260 -T iqn.2017-01.io.k8s:unstaged --logout -I default",
+		"iscsiadm -m node -p 10.0.0.3:3260 -T iqn.2017-01.io.k8s:unstaged -o delete -I default",
+	}, *calls)
+}
@@ -0,0 +1,166 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package iscsi
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/golang/glog"
+	"k8s.io/kubernetes/pkg/util/mount"
+	"k8s.io/kubernetes/pkg/volume/util"
+)
+
+// rescanSessions rescans all the sessions, the kernel updates the size of
+// the devices of the LUNs which grew.
+func rescanSessions(exec mount.Exec) error {
+	out, err := exec.Run("iscsiadm", "-m", "session", "-R")
+	if err != nil && !strings.Contains(string(out), "No active sessions") {
+		return fmt.Errorf("iscsi: failed to rescan sessions: %s (%v)", string(out), err)
+	}
+	return nil
+}
+
+// deviceSize returns the size in bytes of a block device according to
+// sysfs, following the symbolic links to it such as /dev/mapper ones.
+func deviceSize(device string) (int64, error) {
+	if resolved, err := filepath.EvalSymlinks(device); err == nil {
+		device = resolved
+	}
+	data, err := ioutil.ReadFile(filepath.Join(sysBlockPath, filepath.Base(device), "size"))
+	if err != nil {
+		return 0, err
+	}
+	sectors, err := strconv.ParseInt(strings.TrimSpace(string(data)), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid size of %s: %v", device, err)
+	}
+	// sysfs counts 512 bytes sectors whatever the block size
+	return sectors * 512, nil
+}
+
+// resizeMultipath resizes the multipath device mapper if its paths grew.
+func resizeMultipath(exec mount.Exec, deviceUtil util.DeviceUtil, mapper string, dryRun bool) error {
+	dm, err := filepath.EvalSymlinks(mapper)
+	if err != nil {
+		return err
+	}
+	size, err := deviceSize(dm)
+	if err != nil {
+		return err
+	}
+	pathSize := int64(0)
+	for _, slave := range deviceUtil.FindSlaveDevicesOnMultipath(dm) {
+		s, err := deviceSize(slave)
+		if err != nil {
+			glog.V(4).Infof("iscsi: failed to get the size of path %s of %s: %v", slave, mapper, err)
+			continue
+		}
+		if s > pathSize {
+			pathSize = s
+		}
+	}
+	if pathSize <= size {
+		return nil
+	}
+
+	name := filepath.Base(mapper)
+	if dryRun {
+		glog.Infof("iscsi: dry run: would resize multipath device %s from %d to %d bytes", mapper, size, pathSize)
+		return nil
+	}
+	glog.Infof("iscsi: resizing multipath device %s from %d to %d bytes", mapper, size, pathSize)
+	if out, err := exec.Run("multipathd", "resize", "map", name); err != nil {
+		return fmt.Errorf("iscsi: failed to resize multipath device %s: %s (%v)", mapper, string(out), err)
+	}
+	return nil
+}
+
+// findMountPoint returns the mount point of path, nil if nothing is
+// mounted on it.
+func findMountPoint(mounter mount.Interface, path string) (*mount.MountPoint, error) {
+	mps, err := mounter.List()
+	if err != nil {
+		return nil, err
+	}
+	for i := range mps {
+		if mps[i].Path == path {
+			return &mps[i], nil
+		}
+	}
+	return nil, nil
+}
+
+// growFilesystem grows the file system mounted on path to the size of its
+// device.
+func growFilesystem(exec mount.Exec, mp *mount.MountPoint) error {
+	var out []byte
+	var err error
+	switch mp.Type {
+	case "ext2", "ext3", "ext4":
+		out, err = exec.Run("resize2fs", mp.Device)
+	case "xfs":
+		// xfs is grown through its mount point
+		out, err = exec.Run("xfs_growfs", mp.Path)
+	default:
+		glog.Warningf("iscsi: growing %s file systems is not supported, %s keeps its size", mp.Type, mp.Path)
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to grow the file system of %s: %s (%v)", mp.Path, string(out), err)
+	}
+	return nil
+}
+
+// expandVolume grows the multipath device and the file system of a staged
+// volume whose LUN grew, once the sessions are rescanned. The size of the
+// device is recorded in conf, it reports whether it changed.
+func expandVolume(exec mount.Exec, mounter mount.Interface, deviceUtil util.DeviceUtil, conf *iscsiDisk, dryRun bool) (bool, error) {
+	if conf.MultipathDevice != "" {
+		if err := resizeMultipath(exec, deviceUtil, conf.MultipathDevice, dryRun); err != nil {
+			return false, err
+		}
+	}
+
+	// Block volumes have no file system to grow, their size follows the
+	// device
+	mp, err := findMountPoint(mounter, conf.StagingPath)
+	if err != nil || mp == nil {
+		return false, err
+	}
+	size, err := deviceSize(mp.Device)
+	if err != nil {
+		return false, err
+	}
+	if size == conf.DeviceSize {
+		return false, nil
+	}
+
+	if dryRun {
+		glog.Infof("iscsi: dry run: would grow the %s file system of volume %s on %s to %d bytes", mp.Type, conf.VolName, mp.Device, size)
+		return false, nil
+	}
+	glog.Infof("iscsi: growing the %s file system of volume %s on %s to %d bytes", mp.Type, conf.VolName, mp.Device, size)
+	if err := growFilesystem(exec, mp); err != nil {
+		return false, err
+	}
+	conf.DeviceSize = size
+	return true, nil
+}
@@ -0,0 +1,115 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package iscsi
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"k8s.io/kubernetes/pkg/util/mount"
+)
+
+const gibSectors = 2 * 1024 * 1024
+
+// setDeviceSize sets the size of a device in the fake sysfs.
+func setDeviceSize(t *testing.T, dev string, sectors int64) {
+	path := filepath.Join(sysBlockPath, dev, "size")
+	assert.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+	assert.NoError(t, ioutil.WriteFile(path, []byte(strconv.FormatInt(sectors, 10)+"\n"), 0644))
+}
+
+func recordCalls(calls *[]string) mount.Exec {
+	return mount.NewFakeExec(func(cmd string, args ...string) ([]byte, error) {
+		*calls = append(*calls, cmd+" "+strings.Join(args, " "))
+		return nil, nil
+	})
+}
+
+func TestExpandVolume(t *testing.T) {
+	defer fakeSysBlock(t, nil)()
+	setDeviceSize(t, "sdb", 2*gibSectors)
+
+	tests := []struct {
+		fsType   string
+		expected []string
+	}{
+		{"ext4", []string{"resize2fs /dev/sdb"}},
+		{"xfs", []string{"xfs_growfs /staging/vol1"}},
+	}
+	for _, test := range tests {
+		mounter := &mount.FakeMounter{MountPoints: []mount.MountPoint{{Device: "/dev/sdb", Path: "/staging/vol1", Type: test.fsType}}}
+		conf := &iscsiDisk{VolName: "vol1", StagingPath: "/staging/vol1", DeviceSize: gibSectors * 512}
+
+		// The dry run only reports the file system to grow
+		var calls []string
+		changed, err := expandVolume(recordCalls(&calls), mounter, &fakeDeviceUtil{}, conf, true)
+		assert.NoError(t, err)
+		assert.False(t, changed)
+		assert.Empty(t, calls)
+
+		changed, err = expandVolume(recordCalls(&calls), mounter, &fakeDeviceUtil{}, conf, false)
+		assert.NoError(t, err)
+		assert.True(t, changed)
+		assert.Equal(t, test.expected, calls)
+		assert.Equal(t, int64(2*gibSectors*512), conf.DeviceSize)
+
+		// Nothing to do once grown
+		calls = nil
+		changed, err = expandVolume(recordCalls(&calls), mounter, &fakeDeviceUtil{}, conf, false)
+		assert.NoError(t, err)
+		assert.False(t, changed)
+		assert.Empty(t, calls)
+	}
+}
+
+func TestExpandVolumeMultipath(t *testing.T) {
+	defer fakeSysBlock(t, map[string]string{"sdb": "running", "sdc": "running"})()
+	setDeviceSize(t, "dm-0", gibSectors)
+	setDeviceSize(t, "sdb", 2*gibSectors)
+	setDeviceSize(t, "sdc", 2*gibSectors)
+
+	// /dev/mapper/mpatha links to /dev/dm-0
+	dir, err := ioutil.TempDir("", "iscsi-dev")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	dm := filepath.Join(dir, "dm-0")
+	mapper := filepath.Join(dir, "mpatha")
+	assert.NoError(t, ioutil.WriteFile(dm, nil, 0644))
+	assert.NoError(t, os.Symlink(dm, mapper))
+
+	conf := &iscsiDisk{VolName: "vol1", MultipathDevice: mapper}
+	deviceUtil := &fakeDeviceUtil{slaves: []string{"/dev/sdb", "/dev/sdc"}}
+
+	// Block volume, only the map is resized
+	var calls []string
+	changed, err := expandVolume(recordCalls(&calls), &mount.FakeMounter{}, deviceUtil, conf, false)
+	assert.NoError(t, err)
+	assert.False(t, changed)
+	assert.Equal(t, []string{"multipathd resize map mpatha"}, calls)
+
+	// Nothing to do once the map has the size of its paths
+	setDeviceSize(t, "dm-0", 2*gibSectors)
+	calls = nil
+	_, err = expandVolume(recordCalls(&calls), &mount.FakeMounter{}, deviceUtil, conf, false)
+	assert.NoError(t, err)
+	assert.Empty(t, calls)
+}
@@ -0,0 +1,63 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package iscsi
+
+import (
+	"sync"
+)
+
+// sessionLock serializes logging in to targets and recording the volumes
+// with counting the volumes using a session and logging out of it, so that
+// a session is not logged out while another volume starts using it.
+var sessionLock sync.Mutex
+
+// sessionKey identifies an iSCSI session, which all the LUNs of a target
+// reached through the same portal and iface share.
+type sessionKey struct {
+	portal string
+	iqn    string
+	iface  string
+}
+
+// sessionKeys returns the sessions a volume uses.
+func sessionKeys(conf *iscsiDisk) []sessionKey {
+	portals := removeDuplicate(append([]string{}, conf.Portals...))
+	keys := make([]sessionKey, 0, len(portals))
+	for _, portal := range portals {
+		keys = append(keys, sessionKey{portal: portal, iqn: conf.Iqn, iface: conf.Iface})
+	}
+	return keys
+}
+
+// sessionsInUse counts the volumes using each session according to the
+// connection records, except volume volName. sessionLock must be held.
+func (util *ISCSIUtil) sessionsInUse(volName string) (map[sessionKey]int, error) {
+	disks, err := util.listISCSI()
+	if err != nil {
+		return nil, err
+	}
+	inUse := map[sessionKey]int{}
+	for _, disk := range disks {
+		if disk.VolName == volName {
+			continue
+		}
+		for _, key := range sessionKeys(disk) {
+			inUse[key]++
+		}
+	}
+	return inUse, nil
+}
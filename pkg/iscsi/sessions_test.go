@@ -0,0 +1,137 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package iscsi
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"k8s.io/kubernetes/pkg/util/mount"
+)
+
+func TestDetachDiskSharedSession(t *testing.T) {
+	dir, err := ioutil.TempDir("", "iscsi-state")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	defer fakeSysBlock(t, map[string]string{"sdb": "running", "sdc": "running", "sdd": "running", "sde": "running"})()
+
+	util := &ISCSIUtil{stateDir: filepath.Join(dir, "state")}
+	devices := map[string][]string{"lun0": {"sdb", "sdc"}, "lun1": {"sdd", "sde"}}
+	for _, volName := range []string{"lun0", "lun1"} {
+		assert.NoError(t, util.persistISCSI(iscsiDisk{
+			VolName: volName,
+			Portals: []string{"10.0.0.1:3260", "10.0.0.2:3260"},
+			Iqn:     "iqn.shared",
+			Iface:   "default",
+			Devices: devices[volName],
+		}))
+	}
+	// Another target on the same portal
+	assert.NoError(t, util.persistISCSI(iscsiDisk{VolName: "other", Portals: []string{"10.0.0.1:3260"}, Iqn: "iqn.other", Iface: "default"}))
+
+	inUse, err := util.sessionsInUse("lun0")
+	assert.NoError(t, err)
+	assert.Equal(t, map[sessionKey]int{
+		{portal: "10.0.0.1:3260", iqn: "iqn.shared", iface: "default"}: 1,
+		{portal: "10.0.0.2:3260", iqn: "iqn.shared", iface: "default"}: 1,
+		{portal: "10.0.0.1:3260", iqn: "iqn.other", iface: "default"}:  1,
+	}, inUse)
+
+	var calls []string
+	unmounter := iscsiDiskUnmounter{
+		mounter: &mount.FakeMounter{},
+		exec: mount.NewFakeExec(func(cmd string, args ...string) ([]byte, error) {
+			calls = append(calls, cmd+" "+strings.Join(args, " "))
+			return nil, nil
+		}),
+	}
+	detach := func(volName string) {
+		unmounter.iscsiDisk = &iscsiDisk{VolName: volName}
+		assert.NoError(t, util.DetachDisk(unmounter, filepath.Join(dir, volName)))
+	}
+
+	deleted := func(device string) bool {
+		data, err := ioutil.ReadFile(filepath.Join(sysBlockPath, device, "device", "delete"))
+		return err == nil && string(data) == "1"
+	}
+
+	// The sessions are still used by lun1, only the devices of lun0 are
+	// deleted
+	detach("lun0")
+	assert.Empty(t, calls)
+	assert.True(t, deleted("sdb"))
+	assert.True(t, deleted("sdc"))
+	assert.False(t, deleted("sdd"))
+
+	// The last LUN logs out
+	detach("lun1")
+	assert.Equal(t, []string{
+		"iscsiadm -m node -p 10.0.0.1:3260 -T iqn.shared --logout -I default",
+		"iscsiadm -m node -p 10.0.0.1:3260 -T iqn.shared -o delete -I default",
+		"iscsiadm -m node -p 10.0.0.2:3260 -T iqn.shared --logout -I default",
+		"iscsiadm -m node -p 10.0.0.2:3260 -T iqn.shared -o delete -I default",
+	}, calls)
+	// The devices go away with the sessions
+	assert.False(t, deleted("sdd"))
+	assert.False(t, deleted("sde"))
+
+	disks, err := util.listISCSI()
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(disks))
+}
+
+func TestReconcileRecordsSharedSession(t *testing.T) {
+	dir, err := ioutil.TempDir("", "iscsi-state")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	util := &ISCSIUtil{stateDir: filepath.Join(dir, "state")}
+	for _, volName := range []string{"lun0", "lun1"} {
+		assert.NoError(t, util.persistISCSI(iscsiDisk{
+			VolName:     volName,
+			Portals:     []string{"10.0.0.1:3260"},
+			Iqn:         "iqn.shared",
+			Iface:       "default",
+			StagingPath: filepath.Join(dir, volName),
+		}))
+	}
+
+	var calls []string
+	r := &Reconciler{
+		util:    util,
+		mounter: &mount.FakeMounter{},
+		exec: mount.NewFakeExec(func(cmd string, args ...string) ([]byte, error) {
+			calls = append(calls, cmd+" "+strings.Join(args, " "))
+			return nil, nil
+		}),
+	}
+	r.reconcileRecords()
+
+	// Neither volume is staged, the session is logged out once
+	assert.Equal(t, []string{
+		"iscsiadm -m node -p 10.0.0.1:3260 -T iqn.shared --logout -I default",
+		"iscsiadm -m node -p 10.0.0.1:3260 -T iqn.shared -o delete -I default",
+	}, calls)
+	disks, err := util.listISCSI()
+	assert.NoError(t, err)
+	assert.Empty(t, disks)
+}
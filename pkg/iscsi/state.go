@@ -0,0 +1,190 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package iscsi
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/golang/glog"
+	"k8s.io/kubernetes/pkg/util/mount"
+)
+
+// DefaultStateDir is the directory the connection records of the staged
+// volumes are kept in unless configured otherwise.
+const DefaultStateDir = "/var/lib/csi-iscsi"
+
+const stateFileSuffix = ".json"
+
+// stateFile returns the file the connection record of a volume is kept in.
+// Volume IDs may contain slashes, they are escaped.
+func (util *ISCSIUtil) stateFile(volName string) string {
+	return filepath.Join(util.stateDir, url.PathEscape(volName)+stateFileSuffix)
+}
+
+// persistISCSI records the connection of a volume, DetachDisk and the
+// reconciler log out of the target according to it.
+func (util *ISCSIUtil) persistISCSI(conf iscsiDisk) error {
+	if err := os.MkdirAll(util.stateDir, 0700); err != nil {
+		return fmt.Errorf("iscsi: create %s err %s", util.stateDir, err)
+	}
+	data, err := json.Marshal(conf)
+	if err != nil {
+		return fmt.Errorf("iscsi: encode err: %v.", err)
+	}
+
+	// Write the record atomically and durably, a partial or lost record
+	// would leak the session
+	file := util.stateFile(conf.VolName)
+	fp, err := ioutil.TempFile(util.stateDir, ".tmp-")
+	if err != nil {
+		return fmt.Errorf("iscsi: create temporary file in %s err %s", util.stateDir, err)
+	}
+	defer os.Remove(fp.Name())
+	if _, err = fp.Write(data); err == nil {
+		err = fp.Sync()
+	}
+	if closeErr := fp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("iscsi: write %s err %s", fp.Name(), err)
+	}
+	if err := os.Rename(fp.Name(), file); err != nil {
+		return fmt.Errorf("iscsi: create %s err %s", file, err)
+	}
+
+	// Sync the directory so that the rename itself is durable.
+	dir, err := os.Open(util.stateDir)
+	if err != nil {
+		glog.Warningf("iscsi: failed to open %s to sync %s: %v", util.stateDir, file, err)
+		return nil
+	}
+	defer dir.Close()
+	if err := dir.Sync(); err != nil {
+		glog.Warningf("iscsi: failed to sync %s after writing %s: %v", util.stateDir, file, err)
+	}
+	return nil
+}
+
+// loadISCSI reads the connection record of conf.VolName. The error
+// satisfies os.IsNotExist if the volume has no record.
+func (util *ISCSIUtil) loadISCSI(conf *iscsiDisk) error {
+	return loadISCSIFile(util.stateFile(conf.VolName), conf)
+}
+
+func loadISCSIFile(file string, conf *iscsiDisk) error {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	if err = json.Unmarshal(data, conf); err != nil {
+		return fmt.Errorf("iscsi: decode %s err: %v.", file, err)
+	}
+	return nil
+}
+
+func (util *ISCSIUtil) removeISCSI(volName string) error {
+	if err := os.Remove(util.stateFile(volName)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// listISCSI reads the connection records of all the volumes.
+func (util *ISCSIUtil) listISCSI() ([]*iscsiDisk, error) {
+	files, err := ioutil.ReadDir(util.stateDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var disks []*iscsiDisk
+	for _, f := range files {
+		if f.IsDir() || !strings.HasSuffix(f.Name(), stateFileSuffix) {
+			continue
+		}
+		disk := &iscsiDisk{}
+		if err := loadISCSIFile(filepath.Join(util.stateDir, f.Name()), disk); err != nil {
+			glog.Errorf("iscsi: skipping connection record: %v", err)
+			continue
+		}
+		disks = append(disks, disk)
+	}
+	return disks, nil
+}
+
+// isStaged reports whether the volume of a connection record is still
+// mounted on its staging path, as a file system or as a block device.
+func isStaged(mounter mount.Interface, conf *iscsiDisk) (bool, error) {
+	if conf.StagingPath == "" {
+		return false, nil
+	}
+	for _, p := range []string{conf.StagingPath, stagedDevicePath(conf.StagingPath, conf.VolName)} {
+		notMnt, err := mount.IsNotMountPoint(mounter, p)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return false, err
+		}
+		if !notMnt {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// deviceMounted reports whether the multipath device or one of the SCSI
+// devices of a volume is mounted, e.g. on a target path while the staging
+// path is not mounted anymore.
+func deviceMounted(mounter mount.Interface, conf *iscsiDisk) (bool, error) {
+	devices := map[string]bool{}
+	if conf.MultipathDevice != "" {
+		devices[conf.MultipathDevice] = true
+		if dm, err := filepath.EvalSymlinks(conf.MultipathDevice); err == nil {
+			devices[dm] = true
+		}
+	}
+	for _, device := range conf.Devices {
+		devices[filepath.Join("/dev", device)] = true
+	}
+	if len(devices) == 0 {
+		return false, nil
+	}
+
+	mps, err := mounter.List()
+	if err != nil {
+		return false, err
+	}
+	for _, mp := range mps {
+		if devices[mp.Device] {
+			return true, nil
+		}
+		if resolved, err := filepath.EvalSymlinks(mp.Device); err == nil && devices[resolved] {
+			return true, nil
+		}
+	}
+	return false, nil
+}
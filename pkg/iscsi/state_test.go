@@ -0,0 +1,92 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package iscsi
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"k8s.io/kubernetes/pkg/util/mount"
+)
+
+func TestPersistISCSI(t *testing.T) {
+	dir, err := ioutil.TempDir("", "iscsi-state")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	util := &ISCSIUtil{stateDir: filepath.Join(dir, "state")}
+	disk := iscsiDisk{
+		VolName:     "pool/vol1",
+		Portals:     []string{"10.0.0.1:3260"},
+		Iqn:         "iqn.2017-01.io.k8s:vol1",
+		Iface:       "default",
+		StagingPath: "/staging/vol1",
+	}
+	assert.NoError(t, util.persistISCSI(disk))
+
+	loaded := &iscsiDisk{VolName: disk.VolName}
+	assert.NoError(t, util.loadISCSI(loaded))
+	assert.Equal(t, disk, *loaded)
+
+	disks, err := util.listISCSI()
+	assert.NoError(t, err)
+	assert.Equal(t, []*iscsiDisk{&disk}, disks)
+
+	assert.NoError(t, util.removeISCSI(disk.VolName))
+	assert.True(t, os.IsNotExist(util.loadISCSI(loaded)))
+	// Removing a missing record is not an error
+	assert.NoError(t, util.removeISCSI(disk.VolName))
+}
+
+func TestReconcileRecords(t *testing.T) {
+	dir, err := ioutil.TempDir("", "iscsi-state")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	staged := filepath.Join(dir, "staged")
+	unstaged := filepath.Join(dir, "unstaged")
+	assert.NoError(t, os.Mkdir(staged, 0750))
+
+	util := &ISCSIUtil{stateDir: filepath.Join(dir, "state")}
+	assert.NoError(t, util.persistISCSI(iscsiDisk{VolName: "staged", Portals: []string{"10.0.0.1:3260"}, Iqn: "iqn.staged", Iface: "default", StagingPath: staged}))
+	assert.NoError(t, util.persistISCSI(iscsiDisk{VolName: "unstaged", Portals: []string{"10.0.0.1:3260"}, Iqn: "iqn.unstaged", Iface: "default", StagingPath: unstaged}))
+
+	var calls []string
+	exec := mount.NewFakeExec(func(cmd string, args ...string) ([]byte, error) {
+		calls = append(calls, cmd+" "+strings.Join(args, " "))
+		return nil, nil
+	})
+	mounter := &mount.FakeMounter{MountPoints: []mount.MountPoint{{Device: "/dev/sdb", Path: staged}}}
+
+	r := &Reconciler{util: util, mounter: mounter, exec: exec}
+	r.reconcileRecords()
+
+	// Only the volume which is not staged anymore is logged out
+	assert.Equal(t, []string{
+		"iscsiadm -m node -p 10.0.0.1:3260 -T iqn.unstaged --logout -I default",
+		"iscsiadm -m node -p 10.0.0.1:3260 -T iqn.unstaged -o delete -I default",
+	}, calls)
+
+	disks, err := util.listISCSI()
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(disks))
+	assert.Equal(t, "staged", disks[0].VolName)
+}
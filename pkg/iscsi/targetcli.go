@@ -0,0 +1,331 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package iscsi
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/golang/glog"
+	"k8s.io/kubernetes/pkg/util/mount"
+)
+
+var (
+	createdLUNRe = regexp.MustCompile(`Created LUN (\d+)\.`)
+	// Names allowed for LIO storage objects, used as volume IDs
+	backstoreNameRe = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+)
+
+// lioVolume is the record of a volume provisioned in the LIO target, kept
+// next to its backing file.
+type lioVolume struct {
+	Size int64
+	LUN  int
+	// Initiator names the LUN is mapped to
+	Initiators []string
+}
+
+// lioTarget provisions volumes as fileio backstores exported as LUNs of
+// an iSCSI target of the Linux kernel target, configured through
+// targetcli.
+type lioTarget struct {
+	iqn    string
+	portal string
+	// Directory holding the backing files and the records of the volumes
+	dir  string
+	exec mount.Exec
+
+	// targetcli must not run concurrently, it also guards the ACLs shared
+	// by the volumes mapped to a node.
+	mutex sync.Mutex
+}
+
+func newLIOTarget(iqn, portal, dir string, exec mount.Exec) *lioTarget {
+	return &lioTarget{
+		iqn:    iqn,
+		portal: portal,
+		dir:    dir,
+		exec:   exec,
+	}
+}
+
+// targetcli runs a targetcli command, output containing one of the
+// tolerated messages is not an error.
+func (t *lioTarget) targetcli(tolerated []string, args ...string) (string, error) {
+	glog.V(4).Infof("iscsi: targetcli %s", strings.Join(args, " "))
+	out, err := t.exec.Run("targetcli", args...)
+	if err != nil {
+		for _, msg := range tolerated {
+			if strings.Contains(string(out), msg) {
+				glog.V(4).Infof("iscsi: targetcli %s: %s", strings.Join(args, " "), strings.TrimSpace(string(out)))
+				return string(out), nil
+			}
+		}
+		return string(out), fmt.Errorf("targetcli %s failed: %s (%v)", strings.Join(args, " "), strings.TrimSpace(string(out)), err)
+	}
+	return string(out), nil
+}
+
+func (t *lioTarget) tpgPath() string {
+	return "/iscsi/" + t.iqn + "/tpg1"
+}
+
+func (t *lioTarget) saveConfig() error {
+	_, err := t.targetcli(nil, "saveconfig")
+	return err
+}
+
+// ensureTarget creates the iSCSI target if it does not exist yet.
+func (t *lioTarget) ensureTarget() error {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if err := os.MkdirAll(t.dir, 0700); err != nil {
+		return err
+	}
+	if _, err := t.targetcli([]string{"already exists"}, "/iscsi", "create", "wwn="+t.iqn); err != nil {
+		return err
+	}
+	return t.saveConfig()
+}
+
+func (t *lioTarget) imageFile(id string) string {
+	return filepath.Join(t.dir, id+".img")
+}
+
+func (t *lioTarget) recordFile(id string) string {
+	return filepath.Join(t.dir, id+".json")
+}
+
+// getVolume returns the record of volume id, nil if it does not exist.
+func (t *lioTarget) getVolume(id string) (*lioVolume, error) {
+	data, err := ioutil.ReadFile(t.recordFile(id))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	vol := &lioVolume{}
+	if err := json.Unmarshal(data, vol); err != nil {
+		return nil, fmt.Errorf("failed to decode %s: %v", t.recordFile(id), err)
+	}
+	return vol, nil
+}
+
+func (t *lioTarget) saveVolume(id string, vol *lioVolume) error {
+	data, err := json.Marshal(vol)
+	if err != nil {
+		return err
+	}
+	tmp := t.recordFile(id) + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, t.recordFile(id))
+}
+
+// listVolumes returns the records of all the volumes by ID.
+func (t *lioTarget) listVolumes() (map[string]*lioVolume, error) {
+	files, err := filepath.Glob(filepath.Join(t.dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	vols := map[string]*lioVolume{}
+	for _, file := range files {
+		id := strings.TrimSuffix(filepath.Base(file), ".json")
+		vol, err := t.getVolume(id)
+		if err != nil {
+			return nil, err
+		}
+		if vol != nil {
+			vols[id] = vol
+		}
+	}
+	return vols, nil
+}
+
+// createVolume creates the backing file of volume id, its backstore and
+// its LUN.
+func (t *lioTarget) createVolume(id string, size int64) (*lioVolume, error) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	image := t.imageFile(id)
+	if _, err := os.Stat(image); err == nil {
+		// Left over by a creation which failed before recording the volume
+		glog.Warningf("iscsi: deleting the leftovers of volume %s", id)
+		if _, err := t.targetcli([]string{"No storage object"}, "/backstores/fileio", "delete", "name="+id); err != nil {
+			return nil, err
+		}
+	}
+	f, err := os.OpenFile(image, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, err
+	}
+	err = f.Truncate(size)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(image)
+		return nil, err
+	}
+
+	vol, err := t.exportVolume(id, size)
+	if err != nil {
+		// Do not leave a partially created volume behind
+		t.targetcli([]string{"No storage object"}, "/backstores/fileio", "delete", "name="+id)
+		os.Remove(image)
+		return nil, err
+	}
+	return vol, nil
+}
+
+func (t *lioTarget) exportVolume(id string, size int64) (*lioVolume, error) {
+	if _, err := t.targetcli(nil, "/backstores/fileio", "create", "name="+id, "file_or_dev="+t.imageFile(id)); err != nil {
+		return nil, err
+	}
+	out, err := t.targetcli(nil, t.tpgPath()+"/luns", "create", "/backstores/fileio/"+id)
+	if err != nil {
+		return nil, err
+	}
+	match := createdLUNRe.FindStringSubmatch(out)
+	if match == nil {
+		return nil, fmt.Errorf("failed to parse the LUN of volume %s from %q", id, out)
+	}
+	lun, _ := strconv.Atoi(match[1])
+
+	vol := &lioVolume{Size: size, LUN: lun}
+	if err := t.saveVolume(id, vol); err != nil {
+		return nil, err
+	}
+	if err := t.saveConfig(); err != nil {
+		return nil, err
+	}
+	return vol, nil
+}
+
+// deleteVolume deletes the backstore of volume id, along with its LUN and
+// mapped LUNs, and its backing file.
+func (t *lioTarget) deleteVolume(id string) error {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	vol, err := t.getVolume(id)
+	if err != nil || vol == nil {
+		return err
+	}
+	if _, err := t.targetcli([]string{"No storage object"}, "/backstores/fileio", "delete", "name="+id); err != nil {
+		return err
+	}
+	if err := t.saveConfig(); err != nil {
+		return err
+	}
+	// Drop the record last, a failure before leaves the volume to delete
+	if err := os.Remove(t.imageFile(id)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err := os.Remove(t.recordFile(id)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// mapVolume grants initiator access to the LUN of volume id, creating the
+// ACL of the initiator if needed.
+func (t *lioTarget) mapVolume(id, initiator string, readOnly bool) error {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	vol, err := t.getVolume(id)
+	if err != nil {
+		return err
+	}
+	if vol == nil {
+		return os.ErrNotExist
+	}
+	if hasKey(vol.Initiators, initiator) {
+		return nil
+	}
+
+	if _, err := t.targetcli([]string{"already exists"}, t.tpgPath()+"/acls", "create", "wwn="+initiator, "add_mapped_luns=false"); err != nil {
+		return err
+	}
+	lun := strconv.Itoa(vol.LUN)
+	if _, err := t.targetcli([]string{"already exists"}, t.tpgPath()+"/acls/"+initiator, "create", "mapped_lun="+lun, "tpg_lun_or_backstore=lun"+lun, "write_protect="+strconv.FormatBool(readOnly)); err != nil {
+		return err
+	}
+	if err := t.saveConfig(); err != nil {
+		return err
+	}
+
+	vol.Initiators = append(vol.Initiators, initiator)
+	return t.saveVolume(id, vol)
+}
+
+// unmapVolume revokes the access of initiator to the LUN of volume id, and
+// deletes the ACL of the initiator once no volume is mapped to it.
+func (t *lioTarget) unmapVolume(id, initiator string) error {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	vol, err := t.getVolume(id)
+	if err != nil {
+		return err
+	}
+	if vol == nil || !hasKey(vol.Initiators, initiator) {
+		return nil
+	}
+
+	lun := strconv.Itoa(vol.LUN)
+	if _, err := t.targetcli([]string{"No such mapped LUN", "No such path"}, t.tpgPath()+"/acls/"+initiator, "delete", "mapped_lun="+lun); err != nil {
+		return err
+	}
+
+	var initiators []string
+	for _, i := range vol.Initiators {
+		if i != initiator {
+			initiators = append(initiators, i)
+		}
+	}
+	vol.Initiators = initiators
+	if err := t.saveVolume(id, vol); err != nil {
+		return err
+	}
+
+	vols, err := t.listVolumes()
+	if err != nil {
+		return err
+	}
+	for _, v := range vols {
+		if hasKey(v.Initiators, initiator) {
+			return t.saveConfig()
+		}
+	}
+	if _, err := t.targetcli([]string{"No such NodeACL", "No such path"}, t.tpgPath()+"/acls", "delete", "wwn="+initiator); err != nil {
+		return err
+	}
+	return t.saveConfig()
+}
@@ -55,6 +55,7 @@ func NewDriver(nodeID, endpoint string) *driver {
 	// If support is added, it should set to appropriate
 	// ControllerServiceCapability RPC types.
 	csiDriver.AddControllerServiceCapabilities([]csi.ControllerServiceCapability_RPC_Type{csi.ControllerServiceCapability_RPC_UNKNOWN})
+	csiDriver.AddHealthCheck("mount.nfs", csicommon.BinaryHealthCheck("mount.nfs"))
 
 	d.csiDriver = csiDriver
 
@@ -64,15 +65,17 @@ func NewDriver(nodeID, endpoint string) *driver {
 func NewNodeServer(d *driver) *nodeServer {
 	return &nodeServer{
 		DefaultNodeServer: csicommon.NewDefaultNodeServer(d.csiDriver),
+		volumeLocks:       csicommon.NewVolumeLocks(),
+		mounter:           csicommon.NewMounter(),
 	}
 }
 
-func (d *driver) Run() {
+func (d *driver) Run() error {
 	s := csicommon.NewNonBlockingGRPCServer()
 	s.Start(d.endpoint,
 		csicommon.NewDefaultIdentityServer(d.csiDriver),
 		// NFS plugin has not implemented ControllerServer.
 		nil,
 		NewNodeServer(d))
-	s.Wait()
+	return s.Wait()
 }
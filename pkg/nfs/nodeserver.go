@@ -18,60 +18,34 @@ package nfs
 
 import (
 	"fmt"
-	"os"
-	"strings"
 
 	"github.com/container-storage-interface/spec/lib/go/csi/v0"
 	"golang.org/x/net/context"
-	"google.golang.org/grpc/codes"
-	"google.golang.org/grpc/status"
-	"k8s.io/kubernetes/pkg/util/mount"
-	"k8s.io/kubernetes/pkg/volume/util"
 
 	"github.com/kubernetes-csi/drivers/pkg/csi-common"
 )
 
 type nodeServer struct {
 	*csicommon.DefaultNodeServer
+	volumeLocks *csicommon.VolumeLocks
+	mounter     *csicommon.Mounter
 }
 
 func (ns *nodeServer) NodePublishVolume(ctx context.Context, req *csi.NodePublishVolumeRequest) (*csi.NodePublishVolumeResponse, error) {
 	targetPath := req.GetTargetPath()
-	notMnt, err := mount.New("").IsLikelyNotMountPoint(targetPath)
-	if err != nil {
-		if os.IsNotExist(err) {
-			if err := os.MkdirAll(targetPath, 0750); err != nil {
-				return nil, status.Error(codes.Internal, err.Error())
-			}
-			notMnt = true
-		} else {
-			return nil, status.Error(codes.Internal, err.Error())
-		}
+	if err := ns.volumeLocks.Acquire(targetPath); err != nil {
+		return nil, err
 	}
+	defer ns.volumeLocks.Release(targetPath)
 
-	if !notMnt {
-		return &csi.NodePublishVolumeResponse{}, nil
-	}
-
-	mo := req.GetVolumeCapability().GetMount().GetMountFlags()
-	if req.GetReadonly() {
-		mo = append(mo, "ro")
-	}
+	mo := csicommon.MountOptions(req.GetReadonly(), req.GetVolumeCapability().GetMount().GetMountFlags())
 
 	s := req.GetVolumeAttributes()["server"]
 	ep := req.GetVolumeAttributes()["share"]
 	source := fmt.Sprintf("%s:%s", s, ep)
 
-	mounter := mount.New("")
-	err = mounter.Mount(source, targetPath, "nfs", mo)
-	if err != nil {
-		if os.IsPermission(err) {
-			return nil, status.Error(codes.PermissionDenied, err.Error())
-		}
-		if strings.Contains(err.Error(), "invalid argument") {
-			return nil, status.Error(codes.InvalidArgument, err.Error())
-		}
-		return nil, status.Error(codes.Internal, err.Error())
+	if err := ns.mounter.Mount(source, targetPath, "nfs", mo); err != nil {
+		return nil, err
 	}
 
 	return &csi.NodePublishVolumeResponse{}, nil
@@ -79,22 +53,13 @@ func (ns *nodeServer) NodePublishVolume(ctx context.Context, req *csi.NodePublis
 
 func (ns *nodeServer) NodeUnpublishVolume(ctx context.Context, req *csi.NodeUnpublishVolumeRequest) (*csi.NodeUnpublishVolumeResponse, error) {
 	targetPath := req.GetTargetPath()
-	notMnt, err := mount.New("").IsLikelyNotMountPoint(targetPath)
-
-	if err != nil {
-		if os.IsNotExist(err) {
-			return nil, status.Error(codes.NotFound, "Targetpath not found")
-		} else {
-			return nil, status.Error(codes.Internal, err.Error())
-		}
-	}
-	if notMnt {
-		return nil, status.Error(codes.NotFound, "Volume not mounted")
+	if err := ns.volumeLocks.Acquire(targetPath); err != nil {
+		return nil, err
 	}
+	defer ns.volumeLocks.Release(targetPath)
 
-	err = util.UnmountPath(req.GetTargetPath(), mount.New(""))
-	if err != nil {
-		return nil, status.Error(codes.Internal, err.Error())
+	if err := ns.mounter.Unmount(targetPath); err != nil {
+		return nil, err
 	}
 
 	return &csi.NodeUnpublishVolumeResponse{}, nil